@@ -1,7 +1,11 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"image/color"
+	"log"
+	"os"
 
 	"github.com/EngoEngine/ecs"
 	"github.com/EngoEngine/engo"
@@ -10,24 +14,68 @@ import (
 	"re-pair-go/systems"
 )
 
+// The level that is loaded on start
+const levelURL = "levels/default.json"
+
+// localPlayer team controlled by the player at this computer
+const localPlayer systems.Team = 1
+
+// Command line flags
+var (
+	scenarioFile  = flag.String("scenario", "", "run a scenario file without a window and print the final state as JSON")
+	scenarioTicks = flag.Int("ticks", 0, "number of ticks to run the scenario for, overrides the scenario file")
+	stateFile     = flag.String("out", "", "file to write the scenario state to, standard output by default")
+	recordFile    = flag.String("record", "", "record the game into a replay file")
+	replayFile    = flag.String("replay", "", "watch a replay file")
+	hostAddr      = flag.String("host", "", "host a network game on an address, such as :7777")
+	joinAddr      = flag.String("join", "", "join the network game hosted at an address, such as localhost:7777")
+	players       = flag.Int("players", 2, "number of players in a hosted network game")
+)
+
 // DefaultScene the default game scene
-type DefaultScene struct{}
+type DefaultScene struct {
+	level  *systems.Level
+	units  *systems.UnitTypes
+	record *systems.Replay   // replay the game is recorded into, if any
+	replay *systems.Replay   // replay that is watched instead of playing, if any
+	net    *systems.Lockstep // network game, if any
+}
 
 // Type uniquely defines your game type
 func (*DefaultScene) Type() string { return "Re-Pair" }
 
 // Preload is called before loading any assets from the disk,
 // to allow you to register / queue them
-func (*DefaultScene) Preload() {
+func (scene *DefaultScene) Preload() {
 	engo.Files.Load("textures/unit.png")
 	engo.Files.Load("textures/cursor.png")
 	engo.Files.Load("textures/art.png")
 
+	// Replays play out on the unit types and level they were recorded with
+	typesURL, url := systems.UnitTypesURL, levelURL
+	if scene.replay != nil {
+		typesURL, url = scene.replay.UnitTypes, scene.replay.Level
+	}
+	units, err := systems.LoadUnitTypes(typesURL)
+	if err != nil {
+		log.Println(err)
+	} else {
+		scene.units = units
+		engo.Files.Load(units.Images()...)
+	}
+
+	level, err := systems.LoadLevel(url)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	scene.level = level
+	engo.Files.Load(level.Images()...)
 }
 
 // Setup is called before the main loop starts. It allows you to add entities
 // and systems to your Scene.
-func (*DefaultScene) Setup(u engo.Updater) {
+func (scene *DefaultScene) Setup(u engo.Updater) {
 	world, _ := u.(*ecs.World)
 
 	// Input settings
@@ -39,27 +87,145 @@ func (*DefaultScene) Setup(u engo.Updater) {
 	world.AddSystem(&common.RenderSystem{})
 	world.AddSystem(&common.MouseSystem{})
 	world.AddSystem(&common.AnimationSystem{})
-	world.AddSystem(&common.CollisionSystem{Solids: 1})
+	// Units are kept apart by the UnitSpawner, the collision system only reports
+	world.AddSystem(&common.CollisionSystem{})
+
+	// Camera, kept inside the level
+	camera := &systems.CameraControl{Bounds: engo.AABB{Max: engo.Point{X: engo.GameWidth(), Y: engo.GameHeight()}}}
+	if scene.level != nil {
+		camera.Bounds = scene.level.Bounds()
+	}
+	world.AddSystem(camera)
+
+	if scene.replay != nil {
+		scene.setupReplay(world)
+		return
+	}
 
 	// Custom cursor
 	world.AddSystem(&systems.MouseFollower{})
 
 	// Units
-	us := &systems.UnitSpawner{}
+	us := &systems.UnitSpawner{Level: scene.level, Types: scene.units, Player: localPlayer, Record: scene.record, Fog: true}
+	if scene.net != nil {
+		scene.net.Attach(us)
+	}
 	world.AddSystem(us)
-	us.SpawnUnitAtLocation(200, 200, 0)
-	us.SpawnUnitAtLocation(300, 300, 0)
-	us.SpawnUnitAtLocation(400, 400, 1)
-	us.SpawnUnitAtLocation(500, 500, 1)
 
+	// World
+	if scene.level != nil {
+		scene.level.Render(world)
+		for _, spawn := range scene.level.Spawns {
+			if _, err := us.SpawnUnitAtLocation(spawn.Position.X, spawn.Position.Y, spawn.Unit, spawn.Team); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+
+	// Minimap and resource counter, after the spawner and cursor they work with
+	world.AddSystem(&systems.Minimap{})
+	world.AddSystem(&systems.ResourceCounter{})
+
+	// Saves, after the spawner it saves. Loading a save would put a network
+	// game out of step.
+	if scene.net == nil {
+		world.AddSystem(&systems.SaveMenu{})
+	}
+}
+
+// setupReplay add the systems that play back the replay of the scene, there is
+// no cursor as the player only watches
+func (scene *DefaultScene) setupReplay(world *ecs.World) {
+	us := &systems.UnitSpawner{Level: scene.level, Types: scene.units, Player: scene.replay.Player, Source: scene.replay}
+	world.AddSystem(us)
+	world.AddSystem(&systems.ReplayPlayer{Replay: scene.replay})
+	world.AddSystem(&systems.Minimap{})
+	world.AddSystem(&systems.ResourceCounter{})
+
+	if scene.level != nil {
+		scene.level.Render(world)
+	}
+	if err := scene.replay.Setup(us); err != nil {
+		log.Println(err)
+	}
+}
+
+// runScenario run a scenario headless and write its final state
+func runScenario(file string, ticks int, out string) error {
+	scenario, err := systems.LoadScenario(file)
+	if err != nil {
+		return err
+	}
+	if ticks > 0 {
+		scenario.Ticks = ticks
+	}
+	state, err := scenario.Run()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if out == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(out, data, 0644)
+}
+
+// connect host or join a network game, as the flags say
+func connect() (*systems.Lockstep, error) {
+	if *hostAddr != "" {
+		return systems.HostGame(*hostAddr, *players, levelURL)
+	}
+	return systems.JoinGame(*joinAddr, levelURL)
 }
 
 func main() {
+	flag.Parse()
+	if *scenarioFile != "" {
+		if err := runScenario(*scenarioFile, *scenarioTicks, *stateFile); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	scene := &DefaultScene{}
+	if *replayFile != "" {
+		replay, err := systems.LoadReplay(*replayFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		scene.replay = replay
+	} else if *hostAddr != "" || *joinAddr != "" {
+		game, err := connect()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer game.Close()
+		scene.net = game
+	}
+	if *recordFile != "" && scene.replay == nil {
+		player := localPlayer
+		if scene.net != nil {
+			player = scene.net.Team()
+		}
+		scene.record = systems.NewReplay(levelURL, player)
+	}
+
 	opts := engo.RunOptions{
 		Title:          "Re-Pair Game",
 		Width:          960,
 		Height:         1060,
 		StandardInputs: true,
 	}
-	engo.Run(opts, &DefaultScene{})
+	engo.Run(opts, scene)
+
+	if scene.record != nil {
+		if err := scene.record.Save(*recordFile); err != nil {
+			log.Println(err)
+		}
+	}
 }
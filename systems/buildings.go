@@ -0,0 +1,449 @@
+package systems
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/EngoEngine/ecs"
+	"github.com/EngoEngine/engo"
+	"github.com/EngoEngine/engo/common"
+)
+
+// BuildingTypesURL definitions file of the building types, relative to AssetRoot
+const BuildingTypesURL = "buildings/buildings.json"
+
+// BuildingType archetype of a building, everything buildings of the same type
+// share
+type BuildingType struct {
+	Name string `json:"name"`
+	// Size of the footprint in pixels, a multiple of the pathing tile size
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// DropOff workers bring the resources they gather here
+	DropOff bool `json:"dropOff"`
+	HP      int  `json:"hp"`
+	// Cost resources the team pays to build one
+	Cost int `json:"cost"`
+	// Produces the names of the unit types the building trains
+	Produces []string `json:"produces"`
+
+	tiles Point // footprint in pathing tiles
+}
+
+// BuildingTypes registry of the building types, by name
+type BuildingTypes struct {
+	URL   string
+	types map[string]*BuildingType
+}
+
+// buildingTypesFile layout of the definitions file
+type buildingTypesFile struct {
+	Buildings []*BuildingType `json:"buildings"`
+}
+
+// LoadBuildingTypes read the building type definitions from a JSON file
+func LoadBuildingTypes(url string) (*BuildingTypes, error) {
+	data, err := os.ReadFile(filepath.Join(AssetRoot, filepath.FromSlash(url)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read building types %s: %v", url, err)
+	}
+	types, err := ParseBuildingTypes(data)
+	if err != nil {
+		return nil, fmt.Errorf("building types %s: %v", url, err)
+	}
+	types.URL = url
+	return types, nil
+}
+
+// ParseBuildingTypes read building type definitions and check that they are
+// complete
+func ParseBuildingTypes(data []byte) (*BuildingTypes, error) {
+	var file buildingTypesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	types := &BuildingTypes{types: make(map[string]*BuildingType)}
+	for _, t := range file.Buildings {
+		if err := t.validate(); err != nil {
+			return nil, err
+		}
+		t.tiles = Point{X: t.Width / discreteStep, Y: t.Height / discreteStep}
+		if _, ok := types.types[t.Name]; ok {
+			return nil, fmt.Errorf("building type %q is defined twice", t.Name)
+		}
+		types.types[t.Name] = t
+	}
+	return types, nil
+}
+
+// validate check the settings of a building type
+func (t *BuildingType) validate() error {
+	switch {
+	case t.Name == "":
+		return fmt.Errorf("building type without a name")
+	case t.Width <= 0 || t.Height <= 0:
+		return fmt.Errorf("building type %q: size must be positive", t.Name)
+	case t.Width%discreteStep != 0 || t.Height%discreteStep != 0:
+		return fmt.Errorf("building type %q: size must be a multiple of %d", t.Name, discreteStep)
+	case t.HP <= 0:
+		return fmt.Errorf("building type %q: hp must be positive", t.Name)
+	case t.Cost < 0:
+		return fmt.Errorf("building type %q: negative cost", t.Name)
+	}
+	return nil
+}
+
+// produces check if the building type trains the named unit type
+func (t *BuildingType) produces(unitType string) bool {
+	for _, name := range t.Produces {
+		if name == unitType {
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup the building type with the given name
+func (b *BuildingTypes) Lookup(name string) (*BuildingType, error) {
+	t, ok := b.types[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown building type %q", name)
+	}
+	return t, nil
+}
+
+// Names of all building types, sorted
+func (b *BuildingTypes) Names() []string {
+	names := make([]string, 0, len(b.types))
+	for name := range b.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BuildingID identifies a building in commands. IDs are handed out in the
+// order buildings are placed, like unit IDs.
+type BuildingID uint32
+
+// Building structure that stands on a footprint of pathing tiles, which units
+// walk around
+type Building struct {
+	ecs.BasicEntity
+	common.RenderComponent
+	common.SpaceComponent
+	id        BuildingID
+	kind      *BuildingType
+	team      Team
+	rect      tileRect // footprint
+	under     []int    // weights of the footprint tiles before it was blocked
+	hp        int
+	selected  bool
+	hidden    bool // out of sight of the player
+	healthBar HealthBar
+
+	// Production
+	queue    []*UnitType // units to train, the one in training first
+	progress int         // ticks the first unit in the queue has been trained for
+	rally    FixedPoint  // where trained units go
+	hasRally bool
+}
+
+// Type the type of the building
+func (b *Building) Type() *BuildingType {
+	return b.kind
+}
+
+// Team the team that owns the building
+func (b *Building) Team() Team {
+	return b.team
+}
+
+// BuildingID the ID of the building in commands, 0 for no building
+func (b *Building) BuildingID() BuildingID {
+	if b == nil {
+		return 0
+	}
+	return b.id
+}
+
+// HP the hit points the building has left
+func (b *Building) HP() int {
+	return b.hp
+}
+
+// Queue the unit types the building is going to train, the one in training
+// first
+func (b *Building) Queue() []*UnitType {
+	return b.queue
+}
+
+// Select select a building and light up its border
+func (b *Building) Select() {
+	b.selected = true
+	b.outline()
+}
+
+// Deselect deselect a building
+func (b *Building) Deselect() {
+	b.selected = false
+	b.outline()
+}
+
+// outline draw the border of the building, white while it is selected
+func (b *Building) outline() {
+	c := b.team.Color()
+	border := color.RGBA{c.R / 2, c.G / 2, c.B / 2, 255}
+	if b.selected {
+		border = color.RGBA{255, 255, 255, 255}
+	}
+	b.RenderComponent.Drawable = common.Rectangle{BorderWidth: 2, BorderColor: border}
+}
+
+// takeDamage lower the hit points of the building and update its health bar
+func (b *Building) takeDamage(amount int) {
+	b.hp -= amount
+	if b.hp < 0 {
+		b.hp = 0
+	}
+	b.showHealth()
+}
+
+// showHealth size the health bar to the hit points left, it is hidden while
+// the building is unharmed or hidden itself
+func (b *Building) showHealth() {
+	b.healthBar.Width = b.SpaceComponent.Width * float32(b.hp) / float32(b.kind.HP)
+	b.healthBar.RenderComponent.Hidden = b.hidden || b.hp == b.kind.HP
+}
+
+// hide a building and its health bar, or show them again
+func (b *Building) hide(hidden bool) {
+	if b.hidden == hidden {
+		return
+	}
+	b.hidden = hidden
+	b.RenderComponent.Hidden = hidden
+	b.showHealth()
+}
+
+// Buildings that are standing
+func (us *UnitSpawner) Buildings() []*Building {
+	return us.buildings
+}
+
+// PlaceBuilding put up a building of the named type owned by team, with its
+// top left corner at the given position. The footprint has to be free.
+func (us *UnitSpawner) PlaceBuilding(x, y float32, buildingType string, team Team) (*Building, error) {
+	t, err := us.BuildingTypes.Lookup(buildingType)
+	if err != nil {
+		return nil, err
+	}
+	rect := footprint(t, EngoToPathing(engo.Point{X: x, Y: y}))
+	if !us.footprintFree(rect) {
+		return nil, fmt.Errorf("no room for a %s at (%v, %v)", buildingType, x, y)
+	}
+	b := &Building{BasicEntity: ecs.NewBasic(), kind: t, team: team, rect: rect, hp: t.HP}
+	us.addBuilding(b)
+	return b, nil
+}
+
+// footprint the tiles a building of the given type covers with its top left
+// corner on a tile
+func footprint(t *BuildingType, corner Point) tileRect {
+	return tileRect{corner.X, corner.Y, corner.X + t.tiles.X, corner.Y + t.tiles.Y}
+}
+
+// addBuilding block the footprint of a building and draw it, giving it the
+// next building ID unless it has one
+func (us *UnitSpawner) addBuilding(b *Building) {
+	if b.id == 0 {
+		us.lastBuilding++
+		b.id = us.lastBuilding
+	}
+	b.under = b.rect.block(us.ast)
+	us.buildings = append(us.buildings, b)
+	if us.world == nil {
+		return
+	}
+	b.RenderComponent = common.RenderComponent{Color: b.team.Color()}
+	b.outline()
+	// Under the units that walk around it
+	b.RenderComponent.SetZIndex(-0.5)
+	b.SpaceComponent = b.rect.space()
+	b.healthBar = HealthBar{BasicEntity: ecs.NewBasic()}
+	b.healthBar.SpaceComponent = common.SpaceComponent{
+		Position: engo.Point{X: b.SpaceComponent.Position.X, Y: b.SpaceComponent.Position.Y - healthBarHeight},
+		Height:   healthBarHeight,
+	}
+	b.healthBar.RenderComponent = common.RenderComponent{Drawable: common.Rectangle{}, Color: color.RGBA{200, 0, 0, 255}}
+	b.healthBar.RenderComponent.SetZIndex(1)
+	b.showHealth()
+	for _, system := range us.world.Systems() {
+		switch sys := system.(type) {
+		case *common.RenderSystem:
+			sys.Add(&b.BasicEntity, &b.RenderComponent, &b.SpaceComponent)
+			sys.Add(&b.healthBar.BasicEntity, &b.healthBar.RenderComponent, &b.healthBar.SpaceComponent)
+		}
+	}
+}
+
+// destroy remove a building that lost its hit points and free its footprint,
+// the units attacking it stop
+func (us *UnitSpawner) destroy(b *Building) {
+	for i, other := range us.buildings {
+		if other == b {
+			us.buildings = append(us.buildings[:i], us.buildings[i+1:]...)
+			break
+		}
+	}
+	b.rect.unblock(us.ast, b.under)
+	for _, unit := range us.AliveUnits {
+		if unit.siege == b {
+			unit.siege = nil
+			unit.stop()
+			us.paths.Cancel(unit)
+		}
+	}
+	if us.world != nil {
+		us.world.RemoveEntity(b.BasicEntity)
+		us.world.RemoveEntity(b.healthBar.BasicEntity)
+	}
+}
+
+// building the standing building with the given ID, or nil
+func (us *UnitSpawner) building(id BuildingID) *Building {
+	for _, b := range us.buildings {
+		if b.id == id {
+			return b
+		}
+	}
+	return nil
+}
+
+// buildingAt the building that covers a tile, or nil
+func (us *UnitSpawner) buildingAt(p Point) *Building {
+	for _, b := range us.buildings {
+		if b.rect.contains(p) {
+			return b
+		}
+	}
+	return nil
+}
+
+// footprintFree check if every tile of a footprint is inside the grid and
+// passable
+func (us *UnitSpawner) footprintFree(rect tileRect) bool {
+	for x := rect.x0; x < rect.x1; x++ {
+		for y := rect.y0; y < rect.y1; y++ {
+			if us.ast.(Grid).Weight(Point{X: x, Y: y}) == -1 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// CanPlace check if a building of the given type fits with its top left
+// corner at a point: its footprint is free and no unit stands on it
+func (us *UnitSpawner) CanPlace(t *BuildingType, p engo.Point) bool {
+	rect := footprint(t, EngoToPathing(p))
+	if !us.footprintFree(rect) {
+		return false
+	}
+	for _, unit := range us.AliveUnits {
+		if rect.dist(unit.pos) < unit.radius() {
+			return false
+		}
+	}
+	return true
+}
+
+// dropOff the closest building of the team of the unit that takes resources,
+// or nil
+func (us *UnitSpawner) dropOff(unit *BasicUnit) *Building {
+	var nearest *Building
+	var best Fixed
+	for _, b := range us.buildings {
+		if b.team != unit.team || !b.kind.DropOff {
+			continue
+		}
+		if dist := b.rect.dist(unit.pos); nearest == nil || dist < best {
+			nearest, best = b, dist
+		}
+	}
+	return nearest
+}
+
+// block make every tile of the rectangle in the grid impassable, returns the
+// weights the tiles had for unblock to put back
+func (r tileRect) block(ast AStar) []int {
+	grid, _ := ast.(Grid)
+	var weights []int
+	for x := r.x0; x < r.x1; x++ {
+		for y := r.y0; y < r.y1; y++ {
+			p := Point{X: x, Y: y}
+			weight := 0
+			if grid != nil {
+				weight = grid.Weight(p)
+			}
+			weights = append(weights, weight)
+			ast.FillTile(p, -1)
+		}
+	}
+	return weights
+}
+
+// unblock give every tile of the rectangle in the grid back the weight block
+// returned for it, such as the weight of slow terrain
+func (r tileRect) unblock(ast AStar, weights []int) {
+	i := 0
+	for x := r.x0; x < r.x1; x++ {
+		for y := r.y0; y < r.y1; y++ {
+			p := Point{X: x, Y: y}
+			if i < len(weights) && weights[i] != 0 {
+				ast.FillTile(p, weights[i])
+			} else {
+				ast.ClearTile(p)
+			}
+			i++
+		}
+	}
+}
+
+// closest the point of the rectangle, in pixels, that is closest to p
+func (r tileRect) closest(p FixedPoint) FixedPoint {
+	clampFixed := func(v Fixed, min, max int) Fixed {
+		if v < FixedFromInt(min*discreteStep) {
+			return FixedFromInt(min * discreteStep)
+		}
+		if v > FixedFromInt(max*discreteStep) {
+			return FixedFromInt(max * discreteStep)
+		}
+		return v
+	}
+	return FixedPoint{clampFixed(p.X, r.x0, r.x1), clampFixed(p.Y, r.y0, r.y1)}
+}
+
+// dist distance in pixels from p to the rectangle, 0 inside it
+func (r tileRect) dist(p FixedPoint) Fixed {
+	return p.Dist(r.closest(p))
+}
+
+// center of the rectangle, in pixels
+func (r tileRect) center() FixedPoint {
+	return FixedPoint{FixedFromInt((r.x0 + r.x1) * discreteStep / 2), FixedFromInt((r.y0 + r.y1) * discreteStep / 2)}
+}
+
+// space the rectangle in world coordinates
+func (r tileRect) space() common.SpaceComponent {
+	return common.SpaceComponent{
+		Position: engo.Point{X: float32(r.x0 * discreteStep), Y: float32(r.y0 * discreteStep)},
+		Width:    float32((r.x1 - r.x0) * discreteStep),
+		Height:   float32((r.y1 - r.y0) * discreteStep),
+	}
+}
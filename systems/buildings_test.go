@@ -0,0 +1,33 @@
+package systems
+
+import "testing"
+
+func TestFootprintKeepsTerrain(t *testing.T) {
+	us := headlessSpawner(t)
+	defer us.Stop()
+	grid := us.ast.(Grid)
+
+	// Slow terrain under the top left tile of a building and of a node
+	us.ast.FillTile(Point{10, 10}, 3)
+	us.ast.FillTile(Point{30, 30}, 5)
+	b, err := us.PlaceBuilding(80, 80, "depot", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := us.PlaceResource(240, 240, 32, 32, 10)
+	if grid.Weight(Point{10, 10}) != -1 || grid.Weight(Point{30, 30}) != -1 {
+		t.Fatal("the building and the node do not block their footprints")
+	}
+
+	us.destroy(b)
+	us.deplete(node)
+	if w := grid.Weight(Point{10, 10}); w != 3 {
+		t.Errorf("the terrain under the destroyed building has weight %d, want 3", w)
+	}
+	if w := grid.Weight(Point{30, 30}); w != 5 {
+		t.Errorf("the terrain under the depleted node has weight %d, want 5", w)
+	}
+	if w := grid.Weight(Point{11, 11}); w != 0 {
+		t.Errorf("the open ground under the destroyed building has weight %d, want 0", w)
+	}
+}
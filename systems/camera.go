@@ -0,0 +1,208 @@
+package systems
+
+import (
+	"github.com/EngoEngine/ecs"
+	"github.com/EngoEngine/engo"
+	"github.com/EngoEngine/engo/common"
+)
+
+// Names of the input axes used to pan the camera
+const (
+	panHorizontalAxis = "PanHorizontal"
+	panVerticalAxis   = "PanVertical"
+)
+
+// Defaults for the CameraControl settings that are left at zero
+const (
+	defaultScrollSpeed = 600
+	defaultEdgeMargin  = 16
+	defaultZoomSpeed   = 0.125
+	defaultMinZoom     = 0.5
+	defaultMaxZoom     = 2
+)
+
+// CameraControl system that pans the camera with WASD, the arrow keys and the
+// screen edges, zooms it with the mouse wheel, and keeps the view inside Bounds
+type CameraControl struct {
+	// Bounds the world area the view has to stay in, usually the level
+	Bounds engo.AABB
+
+	ScrollSpeed float32 // pixels per second at zoom 1
+	EdgeMargin  float32 // pixels from the window edge that start panning
+	ZoomSpeed   float32 // zoom change per scroll step
+	MinZoom     float32
+	MaxZoom     float32
+
+	camera *common.CameraSystem
+}
+
+// New register the pan axes and find the camera
+func (c *CameraControl) New(w *ecs.World) {
+	if c.ScrollSpeed == 0 {
+		c.ScrollSpeed = defaultScrollSpeed
+	}
+	if c.EdgeMargin == 0 {
+		c.EdgeMargin = defaultEdgeMargin
+	}
+	if c.ZoomSpeed == 0 {
+		c.ZoomSpeed = defaultZoomSpeed
+	}
+	if c.MinZoom == 0 {
+		c.MinZoom = defaultMinZoom
+	}
+	if c.MaxZoom == 0 {
+		c.MaxZoom = defaultMaxZoom
+	}
+
+	engo.Input.RegisterAxis(panHorizontalAxis,
+		engo.AxisKeyPair{Min: engo.KeyA, Max: engo.KeyD},
+		engo.AxisKeyPair{Min: engo.KeyArrowLeft, Max: engo.KeyArrowRight})
+	engo.Input.RegisterAxis(panVerticalAxis,
+		engo.AxisKeyPair{Min: engo.KeyW, Max: engo.KeyS},
+		engo.AxisKeyPair{Min: engo.KeyArrowUp, Max: engo.KeyArrowDown})
+
+	c.camera = findCamera(w)
+	common.CameraBounds = c.Bounds
+}
+
+// Remove is called whenever an Entity is removed from the scene, and thus from this system
+func (*CameraControl) Remove(ecs.BasicEntity) {}
+
+// Update pan and zoom according to the input of this frame
+func (c *CameraControl) Update(dt float32) {
+	if c.camera == nil {
+		return
+	}
+	x, y, z := c.camera.X(), c.camera.Y(), c.camera.Z()
+
+	dx := engo.Input.Axis(panHorizontalAxis).Value()
+	dy := engo.Input.Axis(panVerticalAxis).Value()
+
+	// Edge panning, only while the mouse is inside the window
+	mouse := engo.Input.Mouse
+	if mouse.X >= 0 && mouse.Y >= 0 && mouse.X <= engo.CanvasWidth() && mouse.Y <= engo.CanvasHeight() {
+		if mouse.X < c.EdgeMargin {
+			dx = -1
+		} else if mouse.X > engo.CanvasWidth()-c.EdgeMargin {
+			dx = 1
+		}
+		if mouse.Y < c.EdgeMargin {
+			dy = -1
+		} else if mouse.Y > engo.CanvasHeight()-c.EdgeMargin {
+			dy = 1
+		}
+	}
+
+	// Scrolling up zooms in, which is a smaller z
+	z -= mouse.ScrollY * c.ZoomSpeed
+	if z < c.MinZoom {
+		z = c.MinZoom
+	} else if z > c.MaxZoom {
+		z = c.MaxZoom
+	}
+
+	// Pan at the same speed on screen no matter the zoom
+	x += dx * c.ScrollSpeed * dt * z
+	y += dy * c.ScrollSpeed * dt * z
+	x = clampView(x, engo.GameWidth()/2*z, c.Bounds.Min.X, c.Bounds.Max.X)
+	y = clampView(y, engo.GameHeight()/2*z, c.Bounds.Min.Y, c.Bounds.Max.Y)
+
+	c.LookAt(engo.Point{X: x, Y: y}, z)
+}
+
+// LookAt move the camera to center on a world point at the given zoom
+func (c *CameraControl) LookAt(center engo.Point, z float32) {
+	CenterCamera(center)
+	engo.Mailbox.Dispatch(common.CameraMessage{Axis: common.ZAxis, Value: z})
+}
+
+// CenterCamera move the camera to a world point, keeping the zoom. The
+// CameraControl keeps it inside its bounds.
+func CenterCamera(center engo.Point) {
+	engo.Mailbox.Dispatch(common.CameraMessage{Axis: common.XAxis, Value: center.X})
+	engo.Mailbox.Dispatch(common.CameraMessage{Axis: common.YAxis, Value: center.Y})
+}
+
+// clampView keep a view centered on v with the given half size inside [min, max],
+// or centered on the bounds if the view is larger than them
+func clampView(v, half, min, max float32) float32 {
+	if max-min <= 2*half {
+		return (min + max) / 2
+	}
+	if v-half < min {
+		return min + half
+	}
+	if v+half > max {
+		return max - half
+	}
+	return v
+}
+
+// findCamera the camera system of a world, the RenderSystem adds one
+func findCamera(w *ecs.World) *common.CameraSystem {
+	for _, system := range w.Systems() {
+		switch sys := system.(type) {
+		case *common.CameraSystem:
+			return sys
+		}
+	}
+	return nil
+}
+
+// windowToGame convert a point in window pixels, like the mouse position,
+// to the game's own coordinates. They differ once the window is resized.
+func windowToGame(p engo.Point) engo.Point {
+	if engo.CanvasWidth() == 0 || engo.CanvasHeight() == 0 {
+		return p
+	}
+	return engo.Point{
+		X: p.X * engo.GameWidth() / engo.CanvasWidth(),
+		Y: p.Y * engo.GameHeight() / engo.CanvasHeight(),
+	}
+}
+
+// gameToWindow convert a point in the game's own coordinates to window pixels
+func gameToWindow(p engo.Point) engo.Point {
+	if engo.CanvasWidth() == 0 || engo.CanvasHeight() == 0 {
+		return p
+	}
+	return engo.Point{
+		X: p.X * engo.CanvasWidth() / engo.GameWidth(),
+		Y: p.Y * engo.CanvasHeight() / engo.GameHeight(),
+	}
+}
+
+// ScreenToWorld convert a point in window coordinates, like the mouse
+// position, to world coordinates. Without a camera the world is drawn at
+// game coordinates.
+func ScreenToWorld(camera *common.CameraSystem, p engo.Point) engo.Point {
+	p = windowToGame(p)
+	if camera == nil {
+		return p
+	}
+	z := camera.Z()
+	return engo.Point{
+		X: camera.X() + (p.X-engo.GameWidth()/2)*z,
+		Y: camera.Y() + (p.Y-engo.GameHeight()/2)*z,
+	}
+}
+
+// WorldToScreen convert a point in world coordinates to window coordinates
+func WorldToScreen(camera *common.CameraSystem, p engo.Point) engo.Point {
+	if camera == nil {
+		return gameToWindow(p)
+	}
+	z := camera.Z()
+	return gameToWindow(engo.Point{
+		X: (p.X-camera.X())/z + engo.GameWidth()/2,
+		Y: (p.Y-camera.Y())/z + engo.GameHeight()/2,
+	})
+}
+
+// CameraView the part of the world that is currently on screen
+func CameraView(camera *common.CameraSystem) engo.AABB {
+	return engo.AABB{
+		Min: ScreenToWorld(camera, engo.Point{X: 0, Y: 0}),
+		Max: ScreenToWorld(camera, engo.Point{X: engo.CanvasWidth(), Y: engo.CanvasHeight()}),
+	}
+}
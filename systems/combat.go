@@ -0,0 +1,252 @@
+package systems
+
+import (
+	"image/color"
+
+	"github.com/EngoEngine/ecs"
+	"github.com/EngoEngine/engo"
+	"github.com/EngoEngine/engo/common"
+)
+
+// Combat settings
+const (
+	// Idle units and units on an attack-move pick a target this much further
+	// away than their attack range
+	acquireMargin = 96
+	// A chasing unit searches a new path once its target moved this many tiles
+	// away from the tile it was chasing
+	chaseSlack = 4
+	// Height of the health bar above damaged units, in pixels
+	healthBarHeight = 4
+)
+
+// HealthBar render the health of a damaged unit above it
+type HealthBar struct {
+	ecs.BasicEntity
+	common.RenderComponent
+	common.SpaceComponent
+}
+
+// newHealthBar create the hidden health bar of a unit
+func (unit *BasicUnit) newHealthBar() {
+	unit.healthBar = HealthBar{BasicEntity: ecs.NewBasic()}
+	unit.healthBar.SpaceComponent = common.SpaceComponent{
+		Position: engo.Point{X: unit.SpaceComponent.Position.X, Y: unit.SpaceComponent.Position.Y - healthBarHeight},
+		Width:    unit.SpaceComponent.Width,
+		Height:   healthBarHeight,
+	}
+	unit.healthBar.RenderComponent = common.RenderComponent{Drawable: common.Rectangle{}, Color: color.RGBA{200, 0, 0, 255}, Hidden: true}
+	unit.healthBar.RenderComponent.SetZIndex(1)
+}
+
+// Alive check if the unit has hit points left
+func (unit *BasicUnit) Alive() bool {
+	return unit.hp > 0
+}
+
+// inReach check if the target is within attack range of the unit
+func (unit *BasicUnit) inReach(target *BasicUnit) bool {
+	return unit.pos.Dist(target.pos)-unit.radius()-target.radius() <= unit.kind.reach
+}
+
+// takeDamage lower the hit points of the unit and update its health bar. An
+// idle unit that is hit fights back.
+func (unit *BasicUnit) takeDamage(amount int, attacker *BasicUnit) {
+	unit.hp -= amount
+	if unit.hp < 0 {
+		unit.hp = 0
+	}
+	unit.showHealth()
+
+	if unit.target == nil && !unit.moving() && !unit.gathering() {
+		unit.target = attacker
+	}
+}
+
+// showHealth size the health bar to the hit points left, it is hidden while
+// the unit is unharmed or hidden itself
+func (unit *BasicUnit) showHealth() {
+	unit.healthBar.Width = unit.SpaceComponent.Width * float32(unit.hp) / float32(unit.kind.HP)
+	unit.healthBar.RenderComponent.Hidden = unit.hidden || unit.hp == unit.kind.HP
+}
+
+// clearOrders forget the combat state, group and blocked path of the current
+// order of a unit, the next order replaces them
+func (unit *BasicUnit) clearOrders() {
+	unit.repaths = 0
+	unit.stuck = NotStuck
+	unit.target = nil
+	unit.siege = nil
+	unit.attackMove = false
+	unit.hold = false
+	unit.pace = 0
+}
+
+// AttackTarget order units to chase and attack a single unit
+func (us *UnitSpawner) AttackTarget(units []*BasicUnit, target *BasicUnit) {
+	us.Issue(Command{Units: unitIDs(units), Kind: OrderAttack, Enemy: target.UnitID()})
+}
+
+// AttackMove order units to move to target, fighting any enemy they meet on
+// the way. Once an enemy is dead they continue to the target.
+func (us *UnitSpawner) AttackMove(units []*BasicUnit, target engo.Point) {
+	us.Issue(Command{Units: unitIDs(units), Kind: OrderAttackMove, Target: ToFixedPoint(target), Formation: us.Formation})
+}
+
+// fight let every unit attack, chase or look for its target, or else attack
+// the building it besieges, and remove the units and buildings that died
+func (us *UnitSpawner) fight() {
+	var dead []*BasicUnit
+	var razed []*Building
+	for _, unit := range us.AliveUnits {
+		// Killed earlier this tick, it is removed below
+		if !unit.Alive() {
+			continue
+		}
+		if unit.cooldownLeft > 0 {
+			unit.cooldownLeft--
+		}
+		// Teams can make peace while fighting
+		if unit.target != nil && !us.Hostile(unit, unit.target) {
+			unit.target = nil
+		}
+		if unit.siege != nil && !us.Diplomacy.Hostile(unit.team, unit.siege.team) {
+			unit.siege = nil
+		}
+
+		// Workers keep working when enemies come close
+		if unit.target == nil && !unit.gathering() && (unit.attackMove || !unit.moving() && !us.paths.Pending(unit)) {
+			within := unit.kind.reach + FixedFromInt(acquireMargin)
+			if unit.hold {
+				within = unit.kind.reach
+			}
+			unit.target = us.nearestEnemy(unit, within)
+		}
+		if unit.target == nil && unit.siege != nil {
+			if b := unit.siege; us.besiege(unit) {
+				razed = append(razed, b)
+			}
+			continue
+		}
+		if unit.target == nil {
+			// An attack-move is done once the unit stopped without enemies around
+			if unit.attackMove && !unit.moving() && !us.paths.Pending(unit) {
+				unit.attackMove = false
+			}
+			continue
+		}
+
+		target := unit.target
+		if !target.Alive() {
+			// Killed earlier this tick, the unit loses it once it is removed
+			continue
+		}
+		if !unit.inReach(target) {
+			if unit.hold {
+				unit.target = nil
+			} else {
+				us.chase(unit)
+			}
+			continue
+		}
+		if unit.moving() || us.paths.Pending(unit) {
+			unit.stop()
+			us.paths.Cancel(unit)
+		}
+		if unit.cooldownLeft > 0 {
+			continue
+		}
+		unit.cooldownLeft = unit.kind.cooldownTicks
+		target.takeDamage(unit.kind.Damage, unit)
+		if !target.Alive() {
+			dead = append(dead, target)
+		}
+	}
+
+	for _, unit := range dead {
+		us.kill(unit)
+	}
+	for _, b := range razed {
+		us.destroy(b)
+	}
+}
+
+// besiege walk the unit up to the building it besieges and attack it, report
+// whether the building fell to the blow
+func (us *UnitSpawner) besiege(unit *BasicUnit) bool {
+	b := unit.siege
+	if b.hp == 0 {
+		// Razed by another unit this tick
+		return false
+	}
+	if b.rect.dist(unit.pos)-unit.radius() > unit.kind.reach {
+		if !unit.moving() && !us.paths.Pending(unit) {
+			unit.pace = 0
+			us.paths.Request(unit, us.openSpot(b.rect.closest(unit.pos)))
+		}
+		return false
+	}
+	if unit.moving() || us.paths.Pending(unit) {
+		unit.stop()
+		us.paths.Cancel(unit)
+	}
+	if unit.cooldownLeft > 0 {
+		return false
+	}
+	unit.cooldownLeft = unit.kind.cooldownTicks
+	b.takeDamage(unit.kind.Damage)
+	return b.hp == 0
+}
+
+// chase search a path to the target of the unit, unless the unit is already
+// on its way to where the target is
+func (us *UnitSpawner) chase(unit *BasicUnit) {
+	if us.paths.Pending(unit) {
+		return
+	}
+	tile := FixedToPathing(unit.target.pos)
+	if unit.moving() && unit.chaseTile.Chebyshev(tile) <= chaseSlack {
+		return
+	}
+	unit.chaseTile = tile
+	unit.pace = 0
+	us.paths.Request(unit, unit.target.pos)
+}
+
+// nearestEnemy the closest enemy within the given distance of the unit, or nil
+func (us *UnitSpawner) nearestEnemy(unit *BasicUnit, within Fixed) *BasicUnit {
+	var nearest *BasicUnit
+	for _, other := range us.AliveUnits {
+		if !other.Alive() || !us.Hostile(unit, other) {
+			continue
+		}
+		dist := unit.pos.Dist(other.pos) - unit.radius() - other.radius()
+		if dist <= within {
+			nearest, within = other, dist
+		}
+	}
+	return nearest
+}
+
+// loseTarget called for units whose target is gone. Units on an attack-move
+// continue to where they were sent.
+func (us *UnitSpawner) loseTarget(unit *BasicUnit) {
+	unit.target = nil
+	unit.stop()
+	us.paths.Cancel(unit)
+	if unit.attackMove {
+		us.paths.Request(unit, unit.attackGoal)
+	}
+}
+
+// kill remove a dead unit from the world, every system it was registered with
+// gets to remove it
+func (us *UnitSpawner) kill(unit *BasicUnit) {
+	if us.world == nil {
+		us.Remove(unit.BasicEntity)
+		return
+	}
+	us.world.RemoveEntity(unit.BasicEntity)
+	us.world.RemoveEntity(unit.shadow.BasicEntity)
+	us.world.RemoveEntity(unit.healthBar.BasicEntity)
+}
@@ -0,0 +1,28 @@
+package systems
+
+import "testing"
+
+func TestFightSkipsDeadUnits(t *testing.T) {
+	us := headlessSpawner(t)
+	defer us.Stop()
+	first, err := us.SpawnUnitAtLocation(40, 40, "fish", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := us.SpawnUnitAtLocation(60, 40, "fish", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both are one blow from death and strike in the same tick
+	first.hp, second.hp = 1, 1
+	first.target, second.target = second, first
+	us.fight()
+
+	if us.Unit(first.UnitID()) == nil {
+		t.Error("a unit that died this tick still struck back")
+	}
+	if us.Unit(second.UnitID()) != nil {
+		t.Error("the unit that was struck first is still alive")
+	}
+}
@@ -11,6 +11,15 @@ import (
 
 var firstDragged bool = true
 
+// Names of the order buttons
+const (
+	attackMoveButton = "AttackMove" // arms an attack-move for the next right click
+	patrolButton     = "Patrol"     // arms a patrol for the next right click
+	holdButton       = "Hold"
+	stopButton       = "Stop"
+	formationButton  = "Formation" // switches the formation of group moves
+)
+
 // MouseCursor entity for drawing the cursor
 type MouseCursor struct {
 	base      ecs.BasicEntity
@@ -22,9 +31,22 @@ type MouseCursor struct {
 
 // MouseFollower system that controls the cursor
 type MouseFollower struct {
-	world *ecs.World
+	world  *ecs.World
+	camera *common.CameraSystem
+
+	cursor   MouseCursor
+	selected []*BasicUnit // units selected by the player
+	dragBase []*BasicUnit // selection when a Shift drag started
+	armed    OrderKind    // order given by the next right click, a move if nothing is armed
+	building *Building    // building selected by the player, only when no units are
 
-	cursor MouseCursor
+	placing *BuildingType // type of the building a left click places, nil when not placing
+	ghost   Box           // preview of the building being placed
+
+	groups    [controlGroups][]*BasicUnit
+	lastGroup int     // control group recalled last
+	lastTap   float32 // clock at the last recall
+	clock     float32 // seconds since the system was created
 }
 
 // Box for selection
@@ -37,6 +59,14 @@ type Box struct {
 // New create system that follows the mouse
 func (s *MouseFollower) New(w *ecs.World) {
 	s.world = w
+	s.camera = findCamera(w)
+	engo.Input.RegisterButton(attackMoveButton, engo.KeyQ)
+	engo.Input.RegisterButton(patrolButton, engo.KeyE)
+	engo.Input.RegisterButton(holdButton, engo.KeyH)
+	engo.Input.RegisterButton(stopButton, engo.KeyX)
+	engo.Input.RegisterButton(formationButton, engo.KeyF)
+	registerSelectionButtons()
+	registerBuildingButtons()
 
 	texture, err := common.LoadedSprite("textures/cursor.png")
 	if err != nil {
@@ -55,6 +85,9 @@ func (s *MouseFollower) New(w *ecs.World) {
 		},
 		mouse: common.MouseComponent{Track: true},
 	}
+	// The cursor is drawn in screen space so it keeps its size when zooming,
+	// the selection box lives in the world like the units it selects
+	s.cursor.render.SetShader(common.HUDShader)
 
 	s.cursor.selection = Box{BasicEntity: ecs.NewBasic()}
 	s.cursor.selection.SpaceComponent = common.SpaceComponent{
@@ -63,11 +96,13 @@ func (s *MouseFollower) New(w *ecs.World) {
 		Position: engo.Point{X: 0, Y: 0},
 	}
 	s.cursor.selection.RenderComponent = common.RenderComponent{Drawable: common.Rectangle{}, Color: color.RGBA{0, 0, 100, 50}}
+	s.newGhost()
 	for _, system := range w.Systems() {
 		switch sys := system.(type) {
 		case *common.RenderSystem:
 			sys.Add(&s.cursor.base, &s.cursor.render, &s.cursor.space)
 			sys.Add(&s.cursor.selection.BasicEntity, &s.cursor.selection.RenderComponent, &s.cursor.selection.SpaceComponent)
+			sys.Add(&s.ghost.BasicEntity, &s.ghost.RenderComponent, &s.ghost.SpaceComponent)
 		case *common.MouseSystem:
 			sys.Add(&s.cursor.base, &s.cursor.mouse, &s.cursor.space, &s.cursor.render)
 
@@ -76,8 +111,111 @@ func (s *MouseFollower) New(w *ecs.World) {
 
 }
 
-// Remove follower system from the mouse
-func (*MouseFollower) Remove(basic ecs.BasicEntity) {}
+// Remove drop a removed unit from the selection and the control groups, and
+// a removed building from the selection
+func (s *MouseFollower) Remove(basic ecs.BasicEntity) {
+	if s.building != nil && s.building.ID() == basic.ID() {
+		s.building = nil
+	}
+	s.selected = withoutUnit(s.selected, basic)
+	s.dragBase = withoutUnit(s.dragBase, basic)
+	for i := range s.groups {
+		s.groups[i] = withoutUnit(s.groups[i], basic)
+	}
+}
+
+// arm set the order the next right click gives, the cursor takes the colour
+// of the order while one is armed
+func (s *MouseFollower) arm(kind OrderKind) {
+	s.armed = kind
+	if kind == OrderMove {
+		s.cursor.render.Color = color.White
+	} else {
+		s.cursor.render.Color = waypointColor(kind)
+	}
+}
+
+// command give the selected units an order, queued while Shift is held
+func (s *MouseFollower) command(kind OrderKind, target engo.Point, enemy *BasicUnit) {
+	for _, system := range s.world.Systems() {
+		switch sys := system.(type) {
+		case *UnitSpawner:
+			sys.Issue(Command{
+				Units:     unitIDs(s.selected),
+				Kind:      kind,
+				Target:    ToFixedPoint(target),
+				Enemy:     enemy.UnitID(),
+				Queue:     shiftDown(),
+				Formation: sys.Formation,
+				Team:      sys.Player,
+			})
+		}
+	}
+}
+
+// attackBuilding order the selected units to attack a building
+func (s *MouseFollower) attackBuilding(sys *UnitSpawner, b *Building) {
+	sys.Issue(Command{
+		Units:    unitIDs(s.selected),
+		Kind:     OrderAttack,
+		Building: b.id,
+		Queue:    shiftDown(),
+		Team:     sys.Player,
+	})
+}
+
+// orderAt give the selection the armed order at a world point, a move if
+// nothing is armed
+func (s *MouseFollower) orderAt(target engo.Point) {
+	s.command(s.armed, target, nil)
+	s.arm(OrderMove)
+}
+
+// hoveredEnemy the unit under the mouse if it is an enemy of the group
+func (s *MouseFollower) hoveredEnemy(sys *UnitSpawner, group []*BasicUnit) *BasicUnit {
+	for _, unit := range sys.AliveUnits {
+		if !unit.MouseComponent.Hovered || unit.hidden {
+			// Enemies in the fog can not be picked
+			continue
+		}
+		for _, member := range group {
+			if sys.Hostile(member, unit) {
+				return unit
+			}
+		}
+	}
+	return nil
+}
+
+// hoveredBuilding the building under the mouse if it is a building of an
+// enemy of the group the player sees or has explored
+func (s *MouseFollower) hoveredBuilding(sys *UnitSpawner, group []*BasicUnit, mouse engo.Point) *Building {
+	b := sys.buildingAt(EngoToPathing(mouse))
+	if b == nil || b.hidden {
+		return nil
+	}
+	for _, member := range group {
+		if sys.Diplomacy.Hostile(member.team, b.team) {
+			return b
+		}
+	}
+	return nil
+}
+
+// hoveredNode check if the mouse is over a resource node the player has
+// explored, with a worker in the group
+func (s *MouseFollower) hoveredNode(sys *UnitSpawner, group []*BasicUnit, mouse engo.Point) bool {
+	node := sys.nodeAt(EngoToPathing(mouse))
+	if node == nil || node.RenderComponent.Hidden {
+		return false
+	}
+	for _, member := range group {
+		if member.CanGather() {
+			return true
+		}
+	}
+	return false
+}
 
 // inBox check if a Point is in a Box
 func (s *MouseFollower) inBox(box *Box, point engo.Point) bool {
@@ -104,73 +242,163 @@ func (s *MouseFollower) inBox(box *Box, point engo.Point) bool {
 	return false
 }
 
-func (s *MouseFollower) boxSelect(box *Box) {
+// boxSelect select the units of the player in the box, added to the selection
+// the drag started with when add is set
+func (s *MouseFollower) boxSelect(box *Box, add bool) {
 	for _, system := range s.world.Systems() {
 		switch sys := system.(type) {
 		case *UnitSpawner:
+			var units []*BasicUnit
+			if add {
+				units = append(units, s.dragBase...)
+			}
 			for _, unit := range sys.AliveUnits {
+				if add && containsUnit(s.dragBase, unit) {
+					continue
+				}
 				// Check if unit center in box
-				if s.inBox(box, unit.SpaceComponent.Center()) {
-					unit.Select()
-				} else {
-					unit.Deselect()
-
+				if unit.team == sys.Player && s.inBox(box, unit.SpaceComponent.Center()) {
+					units = append(units, unit)
 				}
 			}
+			s.selectUnits(units)
 		}
 	}
 
 }
 
+// mouseTaken check if the mouse is busy with the HUD: a menu is open or the
+// mouse is over the minimap
+func (s *MouseFollower) mouseTaken() bool {
+	for _, system := range s.world.Systems() {
+		switch sys := system.(type) {
+		case *SaveMenu:
+			if sys.Open() {
+				return true
+			}
+		case *Minimap:
+			if sys.Hovered() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// worldMouse the mouse position in world coordinates
+func (s *MouseFollower) worldMouse() engo.Point {
+	return ScreenToWorld(s.camera, engo.Point{X: engo.Input.Mouse.X, Y: engo.Input.Mouse.Y})
+}
+
 // Update mouse follower's position
 func (s *MouseFollower) Update(dt float32) {
 	// Place cursor sprite at current mouse position
-	s.cursor.space.Position.X = engo.Input.Mouse.X
-	s.cursor.space.Position.Y = engo.Input.Mouse.Y
+	s.cursor.space.Position = windowToGame(engo.Point{X: engo.Input.Mouse.X, Y: engo.Input.Mouse.Y})
+	mouse := s.worldMouse()
+	s.clock += dt
+	// Clicks on the HUD do not reach the world, the keys still do
+	clicks := !s.mouseTaken()
+	for _, system := range s.world.Systems() {
+		switch sys := system.(type) {
+		case *UnitSpawner:
+			s.updateBuilding(sys)
+			if s.updatePlacement(sys, mouse, clicks) {
+				return
+			}
+		}
+	}
+
+	if len(s.selected) > 0 {
+		switch {
+		case engo.Input.Button(attackMoveButton).JustPressed():
+			s.arm(OrderAttackMove)
+		case engo.Input.Button(patrolButton).JustPressed():
+			s.arm(OrderPatrol)
+		case engo.Input.Button(holdButton).JustPressed():
+			s.command(OrderHold, mouse, nil)
+		case engo.Input.Button(stopButton).JustPressed():
+			s.command(OrderStop, mouse, nil)
+		}
+	}
+	s.updateControlGroups()
+	if engo.Input.Button(formationButton).JustPressed() {
+		for _, system := range s.world.Systems() {
+			switch sys := system.(type) {
+			case *UnitSpawner:
+				sys.Formation = sys.Formation.Next()
+				log.Println("Formation:", sys.Formation)
+			}
+		}
+	}
 
 	// Handle mouse clicks and drags
-	if s.cursor.mouse.Clicked {
-		// On left click, if there is no entity, clear selection
+	if clicks && s.cursor.mouse.Clicked {
+		// On left click, if there is no entity, clear selection. Shift adds to
+		// the selection, Control picks every visible unit of the clicked type.
+		// A building of the player is selected on its own.
+		s.arm(OrderMove)
 		for _, system := range s.world.Systems() {
 			switch sys := system.(type) {
 			case *UnitSpawner:
+				var units []*BasicUnit
 				for _, unit := range sys.AliveUnits {
-					if unit.MouseComponent.Hovered {
-						unit.Select()
-					} else {
-						unit.Deselect()
+					if unit.team == sys.Player && unit.MouseComponent.Hovered {
+						units = append(units, unit)
 					}
 				}
+				if controlDown() && len(units) > 0 {
+					units = s.visibleOfType(sys, units[0].kind)
+				}
+				if b := s.clickedBuilding(sys, mouse); b != nil && len(units) == 0 {
+					s.selectUnits(nil)
+					s.selectBuilding(b)
+				} else if shiftDown() {
+					s.addUnits(units)
+				} else {
+					s.selectUnits(units)
+				}
 			}
 		}
-	} else if s.cursor.mouse.RightClicked {
+	} else if clicks && s.cursor.mouse.RightClicked {
+		// Right clicking an enemy attacks it, a resource node sets the workers
+		// to gather it, anywhere else gives the armed order there. With a
+		// building selected it sets the rally point.
 		for _, system := range s.world.Systems() {
 			switch sys := system.(type) {
 			case *UnitSpawner:
-				for _, unit := range sys.AliveUnits {
-					if unit.selected {
-						unit.Move(sys.ast, sys.p2p, s.cursor.space.Position)
-					}
+				if s.building != nil {
+					sys.Issue(Command{Kind: OrderRally, Building: s.building.id, Target: ToFixedPoint(mouse), Team: sys.Player})
+				} else if enemy := s.hoveredEnemy(sys, s.selected); enemy != nil {
+					s.command(OrderAttack, mouse, enemy)
+					s.arm(OrderMove)
+				} else if b := s.hoveredBuilding(sys, s.selected, mouse); b != nil {
+					s.attackBuilding(sys, b)
+					s.arm(OrderMove)
+				} else if s.armed == OrderMove && s.hoveredNode(sys, s.selected, mouse) {
+					s.command(OrderGather, mouse, nil)
+				} else {
+					s.orderAt(mouse)
 				}
 			}
 		}
 
-	} else if s.cursor.mouse.Dragged {
+	} else if clicks && s.cursor.mouse.Dragged {
 		// On drag, select all under the box area
 		if firstDragged {
 			// Initial drag point, origin
-			origin := engo.Point{X: engo.Input.Mouse.X, Y: engo.Input.Mouse.Y}
+			origin := mouse
 			s.cursor.selection.SpaceComponent = common.SpaceComponent{
 				Width:    0,
 				Height:   0,
 				Position: origin,
 			}
+			s.dragBase = append([]*BasicUnit(nil), s.selected...)
 			firstDragged = false
 		} else {
 			// Keep dragging -> increment selection box
-			s.cursor.selection.SpaceComponent.Width = engo.Input.Mouse.X - s.cursor.selection.Position.X
-			s.cursor.selection.SpaceComponent.Height = engo.Input.Mouse.Y - s.cursor.selection.Position.Y
-			s.boxSelect(&s.cursor.selection)
+			s.cursor.selection.SpaceComponent.Width = mouse.X - s.cursor.selection.Position.X
+			s.cursor.selection.SpaceComponent.Height = mouse.Y - s.cursor.selection.Position.Y
+			s.boxSelect(&s.cursor.selection, shiftDown())
 		}
 
 	} else {
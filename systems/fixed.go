@@ -0,0 +1,136 @@
+package systems
+
+import (
+	"math"
+
+	"github.com/EngoEngine/engo"
+)
+
+// Fixed fixed-point number with 16 fractional bits. The simulation keeps
+// positions and distances in fixed-point so that it gives the same result on
+// every machine, floats are only used to draw.
+type Fixed int64
+
+// Fixed-point constants
+const (
+	fixedShift       = 16
+	FixedOne   Fixed = 1 << fixedShift
+)
+
+// FixedFromInt convert an integer to fixed-point
+func FixedFromInt(i int) Fixed {
+	return Fixed(i) << fixedShift
+}
+
+// FixedFromFloat convert a float to the nearest fixed-point number
+func FixedFromFloat(f float32) Fixed {
+	return Fixed(math.Round(float64(f) * float64(FixedOne)))
+}
+
+// Float convert to a float, for drawing
+func (f Fixed) Float() float32 {
+	return float32(f) / float32(FixedOne)
+}
+
+// Int the integer part, rounded down
+func (f Fixed) Int() int {
+	return int(f >> fixedShift)
+}
+
+// Mul multiply two fixed-point numbers
+func (f Fixed) Mul(g Fixed) Fixed {
+	return f * g >> fixedShift
+}
+
+// Div divide two fixed-point numbers
+func (f Fixed) Div(g Fixed) Fixed {
+	return (f << fixedShift) / g
+}
+
+// FixedPoint position or vector in fixed-point
+type FixedPoint struct {
+	X Fixed `json:"x"`
+	Y Fixed `json:"y"`
+}
+
+// ToFixedPoint convert an engo point, for example the mouse position in the
+// world, to fixed-point
+func ToFixedPoint(p engo.Point) FixedPoint {
+	return FixedPoint{FixedFromFloat(p.X), FixedFromFloat(p.Y)}
+}
+
+// Engo convert to an engo point, for drawing
+func (p FixedPoint) Engo() engo.Point {
+	return engo.Point{X: p.X.Float(), Y: p.Y.Float()}
+}
+
+// Add two points
+func (p FixedPoint) Add(q FixedPoint) FixedPoint {
+	return FixedPoint{p.X + q.X, p.Y + q.Y}
+}
+
+// Sub subtract q from p
+func (p FixedPoint) Sub(q FixedPoint) FixedPoint {
+	return FixedPoint{p.X - q.X, p.Y - q.Y}
+}
+
+// Scale multiply both coordinates by s
+func (p FixedPoint) Scale(s Fixed) FixedPoint {
+	return FixedPoint{p.X.Mul(s), p.Y.Mul(s)}
+}
+
+// Dot product of two vectors
+func (p FixedPoint) Dot(q FixedPoint) Fixed {
+	return p.X.Mul(q.X) + p.Y.Mul(q.Y)
+}
+
+// Len length of the vector
+func (p FixedPoint) Len() Fixed {
+	// The square of a fixed-point number has 32 fractional bits, its integer
+	// square root is back at 16
+	return Fixed(isqrt(uint64(p.X*p.X + p.Y*p.Y)))
+}
+
+// Dist distance between two points
+func (p FixedPoint) Dist(q FixedPoint) Fixed {
+	return p.Sub(q).Len()
+}
+
+// Normalize the vector to length one, the zero vector stays zero
+func (p FixedPoint) Normalize() FixedPoint {
+	l := p.Len()
+	if l == 0 {
+		return FixedPoint{}
+	}
+	return FixedPoint{p.X.Div(l), p.Y.Div(l)}
+}
+
+// isqrt integer square root, rounded down
+func isqrt(n uint64) uint64 {
+	var root uint64
+	bit := uint64(1) << 62
+	for bit > n {
+		bit >>= 2
+	}
+	for bit != 0 {
+		if n >= root+bit {
+			n -= root + bit
+			root = root>>1 + bit
+		} else {
+			root >>= 1
+		}
+		bit >>= 2
+	}
+	return root
+}
+
+// FixedToPathing fixed-point position to pathing point
+func FixedToPathing(p FixedPoint) Point {
+	step := FixedFromInt(discreteStep)
+	return Point{X: int(p.X / step), Y: int(p.Y / step)}
+}
+
+// PathingToFixed center of a pathing point in fixed-point
+func PathingToFixed(p Point) FixedPoint {
+	return FixedPoint{FixedFromInt(p.X*discreteStep + discreteStep/2), FixedFromInt(p.Y*discreteStep + discreteStep/2)}
+}
@@ -0,0 +1,167 @@
+package systems
+
+// FlowFielder is implemented by grids that can build flow fields. A flow field
+// is a single search that gives every tile a direction towards one target, so
+// any number of units can share it instead of searching a path each.
+type FlowFielder interface {
+	// FlowField towards target. Fields are cached per target and rebuilt the
+	// next time they are asked for after FillTile or ClearTile changed the grid.
+	FlowField(target Point) *FlowField
+}
+
+// FlowField integration field (the cost of reaching Target from every tile)
+// together with its direction field (the next tile to step to from every tile)
+type FlowField struct {
+	Target Point
+
+	grid    *gridStruct
+	rows    int
+	cols    int
+	version uint64
+	cost    []int
+	next    []Point
+}
+
+func (f *FlowField) index(p Point) (int, bool) {
+	if p.X < 0 || p.X >= f.rows || p.Y < 0 || p.Y >= f.cols {
+		return 0, false
+	}
+	return p.X*f.cols + p.Y, true
+}
+
+// Stale check if the grid changed since the field was built
+func (f *FlowField) Stale() bool {
+	f.grid.tileLock.Lock()
+	defer f.grid.tileLock.Unlock()
+	return f.grid.version != f.version
+}
+
+// Cost of reaching the target from p, or -1 if the target can not be reached
+func (f *FlowField) Cost(p Point) int {
+	i, ok := f.index(p)
+	if !ok {
+		return -1
+	}
+	return f.cost[i]
+}
+
+// Next tile to step to from p. The target points to itself. Returns false if
+// the target can not be reached from p.
+func (f *FlowField) Next(p Point) (Point, bool) {
+	i, ok := f.index(p)
+	if !ok || f.cost[i] < 0 {
+		return Point{}, false
+	}
+	return f.next[i], true
+}
+
+// FlowField implements FlowFielder
+func (a *gridStruct) FlowField(target Point) *FlowField {
+	a.flowLock.Lock()
+	defer a.flowLock.Unlock()
+
+	a.tileLock.Lock()
+	version := a.version
+	a.tileLock.Unlock()
+
+	if field, ok := a.flowFields[target]; ok && field.version == version {
+		return field
+	}
+
+	field := a.buildFlowField(target, version)
+
+	if a.flowFields == nil {
+		a.flowFields = make(map[Point]*FlowField)
+	}
+	// Drop fields for older versions of the grid, they can never be used again
+	for t, f := range a.flowFields {
+		if f.version != version {
+			delete(a.flowFields, t)
+		}
+	}
+	a.flowFields[target] = field
+
+	return field
+}
+
+// buildFlowField run Dijkstra outwards from the target over the whole grid
+func (a *gridStruct) buildFlowField(target Point, version uint64) *FlowField {
+	cost, next := a.dijkstra(target, tileRect{0, 0, a.rows, a.cols})
+	return &FlowField{
+		Target:  target,
+		grid:    a,
+		rows:    a.rows,
+		cols:    a.cols,
+		version: version,
+		cost:    cost,
+		next:    next,
+	}
+}
+
+// dijkstra integrate the cost of reaching target from every tile inside
+// bounds, with the same step costs, fill weights and corner rules as FindPath.
+// Tiles are indexed row by row relative to the corner of bounds. The cost is -1
+// for tiles that can not reach the target, next is the tile to step to.
+func (a *gridStruct) dijkstra(target Point, bounds tileRect) (cost []int, next []Point) {
+	width := bounds.y1 - bounds.y0
+	index := func(p Point) int {
+		return (p.X-bounds.x0)*width + p.Y - bounds.y0
+	}
+
+	size := (bounds.x1 - bounds.x0) * width
+	cost = make([]int, size)
+	next = make([]Point, size)
+	for i := range cost {
+		cost[i] = -1
+	}
+
+	a.tileLock.Lock()
+	blocked := a.filledTiles[target] == -1
+	a.tileLock.Unlock()
+	if !bounds.contains(target) || blocked {
+		return cost, next
+	}
+
+	done := make([]bool, size)
+	open := newOpenList()
+	open.push(&PathPoint{Point: target})
+	cost[index(target)] = 0
+	next[index(target)] = target
+
+	for current := open.popMin(); current != nil; current = open.popMin() {
+		done[index(current.Point)] = true
+
+		for _, p := range a.getSurrounding(current.Point) {
+			if !bounds.contains(p) {
+				continue
+			}
+			pi := index(p)
+			if done[pi] {
+				continue
+			}
+
+			a.tileLock.Lock()
+			fillWeight := a.filledTiles[p]
+			a.tileLock.Unlock()
+			if fillWeight == -1 {
+				continue
+			}
+
+			pcost := current.Weight + a.stepCost(current.Point, p) + fillWeight*a.straightCost
+			if cost[pi] >= 0 && cost[pi] <= pcost {
+				continue
+			}
+
+			candidate := &PathPoint{Point: p, Weight: pcost, DistTraveled: pcost}
+			if cost[pi] < 0 {
+				open.push(candidate)
+			} else {
+				open.decrease(candidate)
+			}
+			cost[pi] = pcost
+			next[pi] = current.Point
+		}
+	}
+
+	return cost, next
+}
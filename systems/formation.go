@@ -0,0 +1,259 @@
+package systems
+
+import (
+	"fmt"
+	"math"
+)
+
+// Formation shape a group takes at the target of a move order
+type Formation int
+
+// Formations, Loose lets the group share a flow field and spread out at the
+// target by itself
+const (
+	FormationBox Formation = iota
+	FormationLine
+	FormationWedge
+	FormationLoose
+	formationCount
+)
+
+// Space between units in a formation, on top of their size
+const formationGap = 8
+
+func (f Formation) String() string {
+	switch f {
+	case FormationBox:
+		return "box"
+	case FormationLine:
+		return "line"
+	case FormationWedge:
+		return "wedge"
+	case FormationLoose:
+		return "loose"
+	}
+	return "unknown"
+}
+
+// MarshalText write the formation by name
+func (f Formation) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+// UnmarshalText read a formation by name
+func (f *Formation) UnmarshalText(text []byte) error {
+	for g := Formation(0); g < formationCount; g++ {
+		if g.String() == string(text) {
+			*f = g
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown formation %q", text)
+}
+
+// Next formation, to cycle through them
+func (f Formation) Next() Formation {
+	return (f + 1) % formationCount
+}
+
+// offsets slot positions of n units relative to the target, in units of the
+// slot spacing. X points to the right of the direction of travel, Y forward.
+func (f Formation) offsets(n int) []FixedPoint {
+	// Slots of rows and lines with an even count sit half a slot off center
+	half := func(twice int) Fixed {
+		return FixedFromInt(twice) / 2
+	}
+	slots := make([]FixedPoint, 0, n)
+	switch f {
+	case FormationLine:
+		for i := 0; i < n; i++ {
+			slots = append(slots, FixedPoint{X: half(2*i - (n - 1))})
+		}
+	case FormationWedge:
+		// The first unit leads, the others fall back to either side of it
+		for i := 0; i < n; i++ {
+			rank := FixedFromInt((i + 1) / 2)
+			if i%2 == 1 {
+				slots = append(slots, FixedPoint{X: -rank, Y: -rank})
+			} else {
+				slots = append(slots, FixedPoint{X: rank, Y: -rank})
+			}
+		}
+	default:
+		// Rows as wide as the box is deep, the last row centered
+		cols := int(isqrt(uint64(n)))
+		if cols*cols < n {
+			cols++
+		}
+		rows := (n + cols - 1) / cols
+		for i := 0; i < n; i++ {
+			row, col := i/cols, i%cols
+			width := cols
+			if row == rows-1 {
+				width = n - row*cols
+			}
+			slots = append(slots, FixedPoint{
+				X: half(2*col - (width - 1)),
+				Y: half(rows - 1 - 2*row),
+			})
+		}
+	}
+	return slots
+}
+
+// formationTargets the target of every unit in a group move to target, the
+// units starting from the given positions. The
+// formation faces the way the group travels, slots on impassable tiles move to
+// the nearest open tile, and units are assigned to slots so that the total
+// distance walked is as small as possible, which keeps their paths from
+// crossing.
+func (us *UnitSpawner) formationTargets(units []*BasicUnit, positions []FixedPoint, target FixedPoint, formation Formation) []FixedPoint {
+	var sum FixedPoint
+	for _, p := range positions {
+		sum = sum.Add(p)
+	}
+	center := FixedPoint{sum.X / Fixed(len(positions)), sum.Y / Fixed(len(positions))}
+	forward := target.Sub(center).Normalize()
+	if forward.X == 0 && forward.Y == 0 {
+		forward = FixedPoint{Y: -FixedOne}
+	}
+	right := FixedPoint{-forward.Y, forward.X}
+
+	var spacing Fixed
+	for _, unit := range units {
+		if d := 2*unit.radius() + FixedFromInt(formationGap); d > spacing {
+			spacing = d
+		}
+	}
+
+	offsets := formation.offsets(len(units))
+	slots := make([]FixedPoint, len(offsets))
+	taken := make(map[Point]bool, len(offsets))
+	for i, o := range offsets {
+		slots[i] = us.openSpotAvoiding(target.Add(right.Scale(o.X).Add(forward.Scale(o.Y)).Scale(spacing)), taken)
+	}
+
+	cost := make([][]int64, len(units))
+	for i, p := range positions {
+		cost[i] = make([]int64, len(slots))
+		for j, slot := range slots {
+			cost[i][j] = int64(p.Dist(slot))
+		}
+	}
+	targets := make([]FixedPoint, len(units))
+	for i, j := range assignSlots(cost) {
+		targets[i] = slots[j]
+	}
+	return targets
+}
+
+// openSpot the center of the nearest tile to p that units can stand on, p
+// itself if the grid can not tell
+func (us *UnitSpawner) openSpot(p FixedPoint) FixedPoint {
+	return us.openSpotAvoiding(p, nil)
+}
+
+// openSpotAvoiding like openSpot, but a spot moved off an impassable tile
+// skips the taken tiles. The tile of the spot is marked taken, so spots
+// handed out one after the other do not stack up on the same open tile.
+func (us *UnitSpawner) openSpotAvoiding(p FixedPoint, taken map[Point]bool) FixedPoint {
+	grid, ok := us.ast.(Grid)
+	if !ok {
+		return p
+	}
+	rows, cols := grid.Size()
+	origin := FixedToPathing(p)
+	if p.X >= 0 && p.Y >= 0 && grid.Weight(origin) != -1 {
+		if taken != nil {
+			taken[origin] = true
+		}
+		return p
+	}
+	// Walk rings of growing size around the tile
+	for r := 1; r < rows+cols; r++ {
+		for dx := -r; dx <= r; dx++ {
+			for _, dy := range []int{-r, r} {
+				for _, q := range []Point{{origin.X + dx, origin.Y + dy}, {origin.X + dy, origin.Y + dx}} {
+					if grid.Weight(q) != -1 && !taken[q] {
+						if taken != nil {
+							taken[q] = true
+						}
+						return PathingToFixed(q)
+					}
+				}
+			}
+		}
+	}
+	return p
+}
+
+// slowestSpeed the speed of the slowest unit
+func slowestSpeed(units []*BasicUnit) Fixed {
+	slowest := Fixed(math.MaxInt64)
+	for _, unit := range units {
+		if unit.speed < slowest {
+			slowest = unit.speed
+		}
+	}
+	return slowest
+}
+
+// assignSlots solve the assignment problem on a square cost matrix with the
+// Hungarian algorithm, returns the column assigned to every row. Costs are
+// integers so that ties are broken the same way everywhere.
+func assignSlots(cost [][]int64) []int {
+	n := len(cost)
+	// Potentials and matching are 1-indexed, column 0 is a dummy
+	u := make([]int64, n+1)
+	v := make([]int64, n+1)
+	match := make([]int, n+1) // row matched to every column
+	way := make([]int, n+1)
+	for i := 1; i <= n; i++ {
+		match[0] = i
+		j0 := 0
+		minv := make([]int64, n+1)
+		used := make([]bool, n+1)
+		for j := range minv {
+			minv[j] = math.MaxInt64
+		}
+		for {
+			used[j0] = true
+			i0, delta, j1 := match[j0], int64(math.MaxInt64), 0
+			for j := 1; j <= n; j++ {
+				if used[j] {
+					continue
+				}
+				cur := cost[i0-1][j-1] - u[i0] - v[j]
+				if cur < minv[j] {
+					minv[j], way[j] = cur, j0
+				}
+				if minv[j] < delta {
+					delta, j1 = minv[j], j
+				}
+			}
+			for j := 0; j <= n; j++ {
+				if used[j] {
+					u[match[j]] += delta
+					v[j] -= delta
+				} else {
+					minv[j] -= delta
+				}
+			}
+			j0 = j1
+			if match[j0] == 0 {
+				break
+			}
+		}
+		for j0 != 0 {
+			j1 := way[j0]
+			match[j0] = match[j1]
+			j0 = j1
+		}
+	}
+
+	rows := make([]int, n)
+	for j := 1; j <= n; j++ {
+		rows[match[j]-1] = j - 1
+	}
+	return rows
+}
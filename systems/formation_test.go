@@ -0,0 +1,44 @@
+package systems
+
+import (
+	"testing"
+
+	"github.com/EngoEngine/engo"
+)
+
+func TestFormationSpreadsAroundWalls(t *testing.T) {
+	us := headlessSpawner(t)
+	defer us.Stop()
+	var units []*BasicUnit
+	var positions []FixedPoint
+	for i := 0; i < 4; i++ {
+		unit, err := us.SpawnUnitAtLocation(40+float32(i)*40, 40, "fish", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		units = append(units, unit)
+		positions = append(positions, unit.pos)
+	}
+
+	// Every slot of the formation lands on a wall, the nearest open tile of
+	// all of them is a lone gap in it
+	target := ToFixedPoint(engo.Point{X: 400, Y: 400})
+	center := FixedToPathing(target)
+	gap := Point{center.X, center.Y - 8}
+	for x := center.X - 20; x <= center.X+20; x++ {
+		for y := center.Y - 20; y <= center.Y+20; y++ {
+			if (Point{x, y}) != gap {
+				us.ast.FillTile(Point{x, y}, -1)
+			}
+		}
+	}
+
+	seen := make(map[Point]bool)
+	for _, p := range us.formationTargets(units, positions, target, FormationBox) {
+		tile := FixedToPathing(p)
+		if seen[tile] {
+			t.Errorf("two units were sent to tile %v", tile)
+		}
+		seen[tile] = true
+	}
+}
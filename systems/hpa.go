@@ -0,0 +1,486 @@
+package systems
+
+import (
+	"sort"
+	"sync"
+)
+
+// DefaultClusterSize width and height, in tiles, of the clusters of a hierarchical grid
+const DefaultClusterSize = 16
+
+// Open stretches along a cluster border up to this many tiles get a single
+// entrance in their middle, longer ones get an entrance at each end
+const maxSingleEntrance = 6
+
+// hpaEntrance a pair of open tiles facing each other across a cluster border
+type hpaEntrance struct {
+	a, b Point
+}
+
+// hpaEdge an edge of the abstract graph. Like the flow fields, the cost
+// includes the fill weight of the tile the edge leaves but not of the one it
+// ends on.
+type hpaEdge struct {
+	to   Point
+	cost int
+}
+
+// hierarchicalGrid HPA* on top of a regular grid. The grid is cut into square
+// clusters, the open stretches along cluster borders become entrance nodes,
+// and the cost between every two entrances of a cluster is precomputed. A
+// search first finds a route over the entrances and then refines it into tiles
+// with small searches that never leave a cluster.
+//
+// FillTile and ClearTile only mark the cluster of the tile as dirty; the
+// entrances on the borders of dirty clusters and the edges around them are
+// rebuilt before the next search, the rest of the graph is kept.
+type hierarchicalGrid struct {
+	grid  *gridStruct
+	local AStarConfig
+	size  int
+
+	clustersX int
+	clustersY int
+
+	graphLock sync.Mutex
+	dirty     map[int]bool
+	// Entrances on the bottom (X+1) and right (Y+1) border of every cluster
+	entrances [][]hpaEntrance
+	// Edges crossing a border, by the tile they leave from
+	inter map[Point][]hpaEdge
+	// Edges between the entrances of every cluster, by the tile they leave from
+	intra []map[Point][]hpaEdge
+}
+
+// NewHierarchicalAStar HPA* over a grid created by NewAStar or NewAStarEightWay,
+// using square clusters of clusterSize tiles. Point to point configs with a
+// single source and target are searched hierarchically, using the config only
+// for its PostProcess step as the search itself uses the grid's own costs.
+// Every other config falls back to a plain search on the grid.
+func NewHierarchicalAStar(base AStar, clusterSize int) AStar {
+	grid, ok := base.(*gridStruct)
+	if !ok {
+		panic("Hierarchical A* needs a grid from NewAStar or NewAStarEightWay")
+	}
+	if clusterSize < 2 {
+		panic("Invalid cluster size specified")
+	}
+
+	h := &hierarchicalGrid{
+		grid:      grid,
+		local:     &pointToPointForward{pointToPoint: pointToPoint{heuristic: grid.heuristic()}},
+		size:      clusterSize,
+		clustersX: (grid.rows + clusterSize - 1) / clusterSize,
+		clustersY: (grid.cols + clusterSize - 1) / clusterSize,
+		dirty:     make(map[int]bool),
+		inter:     make(map[Point][]hpaEdge),
+	}
+	clusters := h.clustersX * h.clustersY
+	h.entrances = make([][]hpaEntrance, clusters)
+	h.intra = make([]map[Point][]hpaEdge, clusters)
+	for c := 0; c < clusters; c++ {
+		h.dirty[c] = true
+	}
+
+	return h
+}
+
+func (h *hierarchicalGrid) FillTile(p Point, weight int) {
+	h.grid.FillTile(p, weight)
+	h.markDirty(p)
+}
+
+func (h *hierarchicalGrid) ClearTile(p Point) {
+	h.grid.ClearTile(p)
+	h.markDirty(p)
+}
+
+func (h *hierarchicalGrid) Size() (rows, cols int) {
+	return h.grid.Size()
+}
+
+func (h *hierarchicalGrid) Weight(p Point) int {
+	return h.grid.Weight(p)
+}
+
+func (h *hierarchicalGrid) Version() uint64 {
+	return h.grid.Version()
+}
+
+func (h *hierarchicalGrid) Changed(since uint64) ([]Point, bool) {
+	return h.grid.Changed(since)
+}
+
+// FlowField implements FlowFielder on the underlying grid
+func (h *hierarchicalGrid) FlowField(target Point) *FlowField {
+	return h.grid.FlowField(target)
+}
+
+func (h *hierarchicalGrid) markDirty(p Point) {
+	h.graphLock.Lock()
+	defer h.graphLock.Unlock()
+	h.dirty[h.clusterOf(p)] = true
+}
+
+func (h *hierarchicalGrid) FindPath(config AStarConfig, source, target []Point) *PathPoint {
+	if len(source) != 1 || len(target) != 1 || !pointToPointConfig(config) {
+		return h.grid.FindPath(config, source, target)
+	}
+
+	h.graphLock.Lock()
+	h.rebuild()
+	route, path := h.route(source[0], target[0])
+	h.graphLock.Unlock()
+
+	if route != nil {
+		path = h.refine(route)
+	}
+	if path == nil {
+		return nil
+	}
+
+	h.grid.tileLock.Lock()
+	path = config.PostProcess(path, h.grid.rows, h.grid.cols, h.grid.filledTiles)
+	h.grid.tileLock.Unlock()
+
+	return path
+}
+
+// pointToPointConfig check if a config searches the cheapest path between two
+// points on the grid's own costs, which is what the hierarchical search finds
+func pointToPointConfig(config AStarConfig) bool {
+	switch config.(type) {
+	case *pointToPointForward, *pointToPointSmooth:
+		return true
+	}
+	return false
+}
+
+// clusterOf index of the cluster containing p
+func (h *hierarchicalGrid) clusterOf(p Point) int {
+	return (p.X/h.size)*h.clustersY + p.Y/h.size
+}
+
+// bounds of a cluster, the last row and column of clusters may be smaller
+func (h *hierarchicalGrid) bounds(c int) tileRect {
+	cx, cy := c/h.clustersY, c%h.clustersY
+	r := tileRect{cx * h.size, cy * h.size, (cx + 1) * h.size, (cy + 1) * h.size}
+	if r.x1 > h.grid.rows {
+		r.x1 = h.grid.rows
+	}
+	if r.y1 > h.grid.cols {
+		r.y1 = h.grid.cols
+	}
+	return r
+}
+
+// neighbours the clusters sharing a border with c
+func (h *hierarchicalGrid) neighbours(c int) []int {
+	cx, cy := c/h.clustersY, c%h.clustersY
+	var result []int
+	if cx > 0 {
+		result = append(result, c-h.clustersY)
+	}
+	if cx < h.clustersX-1 {
+		result = append(result, c+h.clustersY)
+	}
+	if cy > 0 {
+		result = append(result, c-1)
+	}
+	if cy < h.clustersY-1 {
+		result = append(result, c+1)
+	}
+	return result
+}
+
+// rebuild the parts of the abstract graph around dirty clusters, graphLock must be held
+func (h *hierarchicalGrid) rebuild() {
+	if len(h.dirty) == 0 {
+		return
+	}
+
+	rescan := make(map[int]bool)
+	reconnect := make(map[int]bool)
+	for c := range h.dirty {
+		rescan[c] = true
+		reconnect[c] = true
+		for _, n := range h.neighbours(c) {
+			reconnect[n] = true
+			// The borders above and left of c are stored on those clusters
+			if n < c {
+				rescan[n] = true
+			}
+		}
+	}
+	h.dirty = make(map[int]bool)
+
+	// In a fixed order, so the edges of a tile are too
+	clusters := make([]int, 0, len(rescan))
+	for c := range rescan {
+		clusters = append(clusters, c)
+	}
+	sort.Ints(clusters)
+	for _, c := range clusters {
+		h.unlink(h.entrances[c])
+		h.entrances[c] = h.scanEntrances(c)
+		h.link(h.entrances[c])
+	}
+
+	for c := range reconnect {
+		h.intra[c] = h.connect(c)
+	}
+}
+
+// link add the edges crossing the border at the entrances
+func (h *hierarchicalGrid) link(entrances []hpaEntrance) {
+	h.grid.tileLock.Lock()
+	defer h.grid.tileLock.Unlock()
+	for _, e := range entrances {
+		h.inter[e.a] = append(h.inter[e.a], hpaEdge{e.b, h.grid.straightCost + h.grid.filledTiles[e.a]*h.grid.straightCost})
+		h.inter[e.b] = append(h.inter[e.b], hpaEdge{e.a, h.grid.straightCost + h.grid.filledTiles[e.b]*h.grid.straightCost})
+	}
+}
+
+// unlink remove the edges crossing the border at the entrances, a tile can
+// be part of the entrances of two borders so only those edges go
+func (h *hierarchicalGrid) unlink(entrances []hpaEntrance) {
+	drop := func(from, to Point) {
+		edges := h.inter[from][:0]
+		for _, edge := range h.inter[from] {
+			if edge.to != to {
+				edges = append(edges, edge)
+			}
+		}
+		if len(edges) == 0 {
+			delete(h.inter, from)
+		} else {
+			h.inter[from] = edges
+		}
+	}
+	for _, e := range entrances {
+		drop(e.a, e.b)
+		drop(e.b, e.a)
+	}
+}
+
+// scanEntrances find the entrances on the bottom and right border of a cluster
+func (h *hierarchicalGrid) scanEntrances(c int) []hpaEntrance {
+	r := h.bounds(c)
+	var entrances []hpaEntrance
+
+	if r.x1 < h.grid.rows {
+		entrances = h.scanBorder(entrances, r.y1-r.y0, func(i int) hpaEntrance {
+			return hpaEntrance{Point{r.x1 - 1, r.y0 + i}, Point{r.x1, r.y0 + i}}
+		})
+	}
+	if r.y1 < h.grid.cols {
+		entrances = h.scanBorder(entrances, r.x1-r.x0, func(i int) hpaEntrance {
+			return hpaEntrance{Point{r.x0 + i, r.y1 - 1}, Point{r.x0 + i, r.y1}}
+		})
+	}
+
+	return entrances
+}
+
+// scanBorder add an entrance for every open stretch among the n tile pairs of a border
+func (h *hierarchicalGrid) scanBorder(entrances []hpaEntrance, n int, pair func(i int) hpaEntrance) []hpaEntrance {
+	h.grid.tileLock.Lock()
+	defer h.grid.tileLock.Unlock()
+
+	start := -1
+	for i := 0; i <= n; i++ {
+		open := false
+		if i < n {
+			e := pair(i)
+			open = h.grid.filledTiles[e.a] != -1 && h.grid.filledTiles[e.b] != -1
+		}
+
+		if open && start < 0 {
+			start = i
+		} else if !open && start >= 0 {
+			end := i - 1
+			if end-start+1 <= maxSingleEntrance {
+				entrances = append(entrances, pair(start+(end-start)/2))
+			} else {
+				entrances = append(entrances, pair(start), pair(end))
+			}
+			start = -1
+		}
+	}
+
+	return entrances
+}
+
+// nodes the entrance tiles inside a cluster, in a fixed order
+func (h *hierarchicalGrid) nodes(c int) []Point {
+	seen := make(map[Point]bool)
+	var nodes []Point
+	add := func(p Point) {
+		if h.clusterOf(p) == c && !seen[p] {
+			seen[p] = true
+			nodes = append(nodes, p)
+		}
+	}
+
+	owners := append([]int{c}, h.neighbours(c)...)
+	for _, o := range owners {
+		for _, e := range h.entrances[o] {
+			add(e.a)
+			add(e.b)
+		}
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].X != nodes[j].X {
+			return nodes[i].X < nodes[j].X
+		}
+		return nodes[i].Y < nodes[j].Y
+	})
+	return nodes
+}
+
+// connect compute the edges between all entrances of a cluster
+func (h *hierarchicalGrid) connect(c int) map[Point][]hpaEdge {
+	r := h.bounds(c)
+	width := r.y1 - r.y0
+	nodes := h.nodes(c)
+
+	edges := make(map[Point][]hpaEdge)
+	for _, to := range nodes {
+		cost, _ := h.grid.dijkstra(to, r)
+		for _, from := range nodes {
+			if from == to {
+				continue
+			}
+			if d := cost[(from.X-r.x0)*width+from.Y-r.y0]; d >= 0 {
+				edges[from] = append(edges[from], hpaEdge{to, d})
+			}
+		}
+	}
+
+	return edges
+}
+
+// route search the abstract graph, returns the tiles to pass from source to
+// target or nil if there is no route. When the target can be reached without
+// leaving the cluster of the source the path found doing so is returned
+// instead of a route. graphLock must be held.
+func (h *hierarchicalGrid) route(source, target Point) ([]Point, *PathPoint) {
+	grid := h.grid
+	if source.X < 0 || source.X >= grid.rows || source.Y < 0 || source.Y >= grid.cols ||
+		target.X < 0 || target.X >= grid.rows || target.Y < 0 || target.Y >= grid.cols {
+		return nil, nil
+	}
+
+	grid.tileLock.Lock()
+	sourceFill := grid.filledTiles[source]
+	blocked := sourceFill == -1 || grid.filledTiles[target] == -1
+	grid.tileLock.Unlock()
+	if blocked {
+		return nil, nil
+	}
+
+	sc, tc := h.clusterOf(source), h.clusterOf(target)
+	if sc == tc {
+		if path := grid.search(h.local, []Point{source}, []Point{target}, h.bounds(sc)); path != nil {
+			return nil, path
+		}
+	}
+
+	// Connect the source and target to the entrances of their clusters
+	var startEdges []hpaEdge
+	sr := h.bounds(sc)
+	fromSource, _ := grid.dijkstra(source, sr)
+	for _, n := range h.nodes(sc) {
+		if d := fromSource[(n.X-sr.x0)*(sr.y1-sr.y0)+n.Y-sr.y0]; d >= 0 {
+			// Reverse the cost: it now leaves the source instead of the entrance
+			grid.tileLock.Lock()
+			d += (sourceFill - grid.filledTiles[n]) * grid.straightCost
+			grid.tileLock.Unlock()
+			startEdges = append(startEdges, hpaEdge{n, d})
+		}
+	}
+	tr := h.bounds(tc)
+	toTarget, _ := grid.dijkstra(target, tr)
+	goalCost := func(p Point) int {
+		if h.clusterOf(p) != tc {
+			return -1
+		}
+		return toTarget[(p.X-tr.x0)*(tr.y1-tr.y0)+p.Y-tr.y0]
+	}
+
+	heuristic := grid.heuristic()
+	open := newOpenList()
+	closed := make(map[Point]bool)
+	open.push(&PathPoint{Point: source, Weight: heuristic(source, target)})
+
+	var end *PathPoint
+	for current := open.popMin(); current != nil; current = open.popMin() {
+		if current.Point == target {
+			end = current
+			break
+		}
+		closed[current.Point] = true
+
+		edges := append([]hpaEdge{}, h.intra[h.clusterOf(current.Point)][current.Point]...)
+		edges = append(edges, h.inter[current.Point]...)
+		if current.Point == source {
+			edges = append(edges, startEdges...)
+		}
+		if d := goalCost(current.Point); d >= 0 {
+			edges = append(edges, hpaEdge{target, d})
+		}
+
+		for _, e := range edges {
+			if closed[e.to] {
+				continue
+			}
+			g := current.DistTraveled + e.cost
+			candidate := &PathPoint{
+				Point:        e.to,
+				Parent:       current,
+				DistTraveled: g,
+				Weight:       g + heuristic(e.to, target),
+			}
+			if existing, ok := open.get(e.to); !ok {
+				open.push(candidate)
+			} else if candidate.Weight < existing.Weight {
+				open.decrease(candidate)
+			}
+		}
+	}
+	if end == nil {
+		return nil, nil
+	}
+
+	var route []Point
+	for p := end; p != nil; p = p.Parent {
+		route = append([]Point{p.Point}, route...)
+	}
+	return route, nil
+}
+
+// refine turn a route into a chain of tiles, starting at the source
+func (h *hierarchicalGrid) refine(route []Point) *PathPoint {
+	first := &PathPoint{Point: route[0]}
+	last := first
+	for i := 1; i < len(route); i++ {
+		from, to := route[i-1], route[i]
+		if h.clusterOf(from) != h.clusterOf(to) {
+			// Crossing a border, the tiles are next to each other
+			last.Parent = &PathPoint{Point: to}
+			last = last.Parent
+			continue
+		}
+
+		segment := h.grid.search(h.local, []Point{from}, []Point{to}, h.bounds(h.clusterOf(from)))
+		if segment == nil {
+			return nil
+		}
+		for p := segment.Parent; p != nil; p = p.Parent {
+			last.Parent = &PathPoint{Point: p.Point}
+			last = last.Parent
+		}
+	}
+	return first
+}
@@ -0,0 +1,50 @@
+package systems
+
+import "testing"
+
+func TestHierarchicalFallsBackForOtherConfigs(t *testing.T) {
+	grid := NewAStar(64, 64)
+	h := NewHierarchicalAStar(NewAStar(64, 64), DefaultClusterSize)
+	source, target := []Point{{0, 0}}, []Point{{40, 50}}
+	plain := grid.FindPath(NewRowToRow(), source, target)
+	hierarchical := h.FindPath(NewRowToRow(), source, target)
+	if hierarchical == nil || plain == nil {
+		t.Fatalf("row to row found a path %v on the hierarchical grid and %v on the plain one", hierarchical != nil, plain != nil)
+	}
+	if a, b := pathCost(grid.(*gridStruct), hierarchical), pathCost(grid.(*gridStruct), plain); a != b {
+		t.Errorf("row to row costs %d on the hierarchical grid, %d on the plain one", a, b)
+	}
+}
+
+func TestHierarchicalIncrementalRebuild(t *testing.T) {
+	const size = 64
+	h := NewHierarchicalAStar(NewAStar(size, size), DefaultClusterSize).(*hierarchicalGrid)
+	source, target := []Point{{0, 0}}, []Point{{size - 1, size - 1}}
+	h.FindPath(NewPointToPoint(), source, target)
+
+	// Wall off most of a row across several clusters, then open part of it
+	for y := 0; y < size-3; y++ {
+		h.FillTile(Point{20, y}, -1)
+	}
+	h.FindPath(NewPointToPoint(), source, target)
+	for y := 30; y < 34; y++ {
+		h.ClearTile(Point{20, y})
+	}
+	h.FillTile(Point{40, 10}, 5)
+
+	fresh := NewHierarchicalAStar(NewAStar(size, size), DefaultClusterSize).(*hierarchicalGrid)
+	for p, weight := range h.grid.filledTiles {
+		fresh.FillTile(p, weight)
+	}
+	incremental := h.FindPath(NewPointToPoint(), source, target)
+	rebuilt := fresh.FindPath(NewPointToPoint(), source, target)
+	if incremental == nil || rebuilt == nil {
+		t.Fatalf("found a path %v after incremental rebuilds and %v after a full one", incremental != nil, rebuilt != nil)
+	}
+	if a, b := pathCost(h.grid, incremental), pathCost(fresh.grid, rebuilt); a != b {
+		t.Errorf("path costs %d after incremental rebuilds and %d after a full one", a, b)
+	}
+	if len(h.inter) != len(fresh.inter) {
+		t.Errorf("%d tiles have border edges after incremental rebuilds, %d after a full one", len(h.inter), len(fresh.inter))
+	}
+}
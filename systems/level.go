@@ -0,0 +1,532 @@
+package systems
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/EngoEngine/ecs"
+	"github.com/EngoEngine/engo"
+	"github.com/EngoEngine/engo/common"
+)
+
+// AssetRoot directory that asset urls are relative to, the same as engo uses
+// by default. Tests run from the package directory and point it up a level.
+var AssetRoot = "assets"
+
+// Name of the obstacle layer and types of the objects in a level
+const (
+	obstacleLayer = "obstacles"
+	spawnType     = "spawn"
+	resourceType  = "resource"
+	buildingType  = "building"
+)
+
+// The top bits of a tile gid are flags for flipping the tile
+const gidFlags = 0xE0000000
+
+// Level a map made with Tiled and saved as JSON (.json) or XML (.tmx) with
+// CSV tile data and embedded tilesets.
+//
+// Tile layers are drawn in order. The layer named "obstacles" is also put in
+// the pathing grid: every tile in it is filled with the "weight" property of
+// its tileset tile, -1 for impassable and positive for slow terrain. Objects
+// of type "spawn" are the units that are in the level from the start,
+// "resource" objects are resource nodes covering the rectangle of the object
+// and "building" objects the buildings the teams start with.
+type Level struct {
+	URL        string
+	Width      int // in tiles
+	Height     int // in tiles
+	TileWidth  int // in pixels
+	TileHeight int // in pixels
+
+	Layers    []LevelLayer
+	Tilesets  []LevelTileset
+	Spawns    []SpawnPoint
+	Resources []ResourcePoint
+	Buildings []BuildingPoint
+
+	tiles []*levelTile
+}
+
+// LevelLayer a layer of tiles, Data holds a gid for every tile row by row where 0 is empty
+type LevelLayer struct {
+	Name string
+	Data []uint32
+}
+
+// LevelTileset tiles cut from a single image, with per tile properties
+type LevelTileset struct {
+	FirstGID   uint32
+	Image      string // asset url
+	TileWidth  int
+	TileHeight int
+	TileCount  int
+	Weights    map[int]int // pathing weight by tile id
+
+	sheet *common.Spritesheet
+}
+
+// SpawnPoint where a unit is placed when the level starts
+type SpawnPoint struct {
+	Position engo.Point
+	Unit     string // unit type name
+	Team     Team
+}
+
+// ResourcePoint resource node in the level, Position is its top left corner
+type ResourcePoint struct {
+	Position      engo.Point
+	Width, Height float32 // in pixels
+	Amount        int
+}
+
+// BuildingPoint building a team starts with, Position is its top left corner
+type BuildingPoint struct {
+	Position engo.Point
+	Building string // building type name
+	Team     Team
+}
+
+// levelTile render entity for a single tile
+type levelTile struct {
+	ecs.BasicEntity
+	common.RenderComponent
+	common.SpaceComponent
+}
+
+// LoadLevel read a level from the assets directory
+func LoadLevel(url string) (*Level, error) {
+	data, err := os.ReadFile(filepath.Join(AssetRoot, filepath.FromSlash(url)))
+	if err != nil {
+		return nil, err
+	}
+
+	var level *Level
+	switch strings.ToLower(path.Ext(url)) {
+	case ".json":
+		level, err = parseTiledJSON(data)
+	case ".tmx":
+		level, err = parseTiledTMX(data)
+	default:
+		err = fmt.Errorf("unknown level format %q", path.Ext(url))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading level %s: %v", url, err)
+	}
+
+	level.URL = url
+	for i := range level.Tilesets {
+		// Tiled stores images relative to the map file
+		level.Tilesets[i].Image = path.Join(path.Dir(url), level.Tilesets[i].Image)
+	}
+	for _, layer := range level.Layers {
+		if len(layer.Data) != level.Width*level.Height {
+			return nil, fmt.Errorf("loading level %s: layer %q has %d tiles, expected %d",
+				url, layer.Name, len(layer.Data), level.Width*level.Height)
+		}
+	}
+	if level.TileWidth%discreteStep != 0 || level.TileHeight%discreteStep != 0 {
+		return nil, fmt.Errorf("loading level %s: tile size must be a multiple of %d", url, discreteStep)
+	}
+
+	return level, nil
+}
+
+// Images the tileset images to load during Preload
+func (l *Level) Images() []string {
+	var images []string
+	for _, ts := range l.Tilesets {
+		images = append(images, ts.Image)
+	}
+	return images
+}
+
+// GridSize size of the pathing grid that covers the level
+func (l *Level) GridSize() (rows, cols int) {
+	return l.Width * l.TileWidth / discreteStep, l.Height * l.TileHeight / discreteStep
+}
+
+// Bounds the level in world coordinates
+func (l *Level) Bounds() engo.AABB {
+	return engo.AABB{Max: engo.Point{X: float32(l.Width * l.TileWidth), Y: float32(l.Height * l.TileHeight)}}
+}
+
+// tileset the tileset and tile id of a gid
+func (l *Level) tileset(gid uint32) (*LevelTileset, int) {
+	gid &^= gidFlags
+	var found *LevelTileset
+	for i := range l.Tilesets {
+		if l.Tilesets[i].FirstGID <= gid && (found == nil || l.Tilesets[i].FirstGID > found.FirstGID) {
+			found = &l.Tilesets[i]
+		}
+	}
+	if found == nil {
+		return nil, 0
+	}
+	return found, int(gid - found.FirstGID)
+}
+
+// FillGrid put the obstacle layer into the pathing grid
+func (l *Level) FillGrid(ast AStar) {
+	cellsX, cellsY := l.TileWidth/discreteStep, l.TileHeight/discreteStep
+	for _, layer := range l.Layers {
+		if layer.Name != obstacleLayer {
+			continue
+		}
+		for i, gid := range layer.Data {
+			if gid == 0 {
+				continue
+			}
+			ts, id := l.tileset(gid)
+			if ts == nil {
+				continue
+			}
+			weight, ok := ts.Weights[id]
+			if !ok || weight == 0 {
+				continue
+			}
+
+			tx, ty := i%l.Width, i/l.Width
+			for x := 0; x < cellsX; x++ {
+				for y := 0; y < cellsY; y++ {
+					ast.FillTile(Point{X: tx*cellsX + x, Y: ty*cellsY + y}, weight)
+				}
+			}
+		}
+	}
+}
+
+// Render add all tiles of the level to the render system, behind the units
+func (l *Level) Render(w *ecs.World) {
+	for i := range l.Tilesets {
+		ts := &l.Tilesets[i]
+		ts.sheet = common.NewSpritesheetFromFile(ts.Image, ts.TileWidth, ts.TileHeight)
+	}
+
+	l.tiles = nil
+	for z, layer := range l.Layers {
+		for i, gid := range layer.Data {
+			if gid == 0 {
+				continue
+			}
+			ts, id := l.tileset(gid)
+			if ts == nil {
+				continue
+			}
+
+			tile := &levelTile{BasicEntity: ecs.NewBasic()}
+			tile.RenderComponent = common.RenderComponent{
+				Drawable: ts.sheet.Cell(id),
+				Scale: engo.Point{
+					X: float32(l.TileWidth) / float32(ts.TileWidth),
+					Y: float32(l.TileHeight) / float32(ts.TileHeight),
+				},
+			}
+			tile.RenderComponent.SetZIndex(float32(z - len(l.Layers)))
+			tile.SpaceComponent = common.SpaceComponent{
+				Position: engo.Point{X: float32(i % l.Width * l.TileWidth), Y: float32(i / l.Width * l.TileHeight)},
+				Width:    float32(l.TileWidth),
+				Height:   float32(l.TileHeight),
+			}
+			l.tiles = append(l.tiles, tile)
+		}
+	}
+
+	for _, system := range w.Systems() {
+		switch sys := system.(type) {
+		case *common.RenderSystem:
+			for _, tile := range l.tiles {
+				sys.Add(&tile.BasicEntity, &tile.RenderComponent, &tile.SpaceComponent)
+			}
+		}
+	}
+}
+
+//######################################################################
+// TILED JSON AND TMX
+//######################################################################
+
+type tiledProperty struct {
+	Name string `json:"name" xml:"name,attr"`
+	// JSON keeps the type of the value, TMX always stores it as a string
+	Value    interface{} `json:"value" xml:"-"`
+	XMLValue string      `json:"-" xml:"value,attr"`
+}
+
+type tiledTile struct {
+	ID         int             `json:"id" xml:"id,attr"`
+	Properties []tiledProperty `json:"properties" xml:"properties>property"`
+}
+
+type tiledObject struct {
+	Type       string          `json:"type" xml:"type,attr"`
+	Class      string          `json:"class" xml:"class,attr"`
+	X          float32         `json:"x" xml:"x,attr"`
+	Y          float32         `json:"y" xml:"y,attr"`
+	Width      float32         `json:"width" xml:"width,attr"`
+	Height     float32         `json:"height" xml:"height,attr"`
+	Properties []tiledProperty `json:"properties" xml:"properties>property"`
+}
+
+type tiledJSONMap struct {
+	Width      int `json:"width"`
+	Height     int `json:"height"`
+	TileWidth  int `json:"tilewidth"`
+	TileHeight int `json:"tileheight"`
+	Layers     []struct {
+		Name    string        `json:"name"`
+		Type    string        `json:"type"`
+		Data    []uint32      `json:"data"`
+		Objects []tiledObject `json:"objects"`
+	} `json:"layers"`
+	Tilesets []struct {
+		FirstGID   uint32      `json:"firstgid"`
+		Source     string      `json:"source"`
+		Image      string      `json:"image"`
+		TileWidth  int         `json:"tilewidth"`
+		TileHeight int         `json:"tileheight"`
+		TileCount  int         `json:"tilecount"`
+		Tiles      []tiledTile `json:"tiles"`
+	} `json:"tilesets"`
+}
+
+type tiledTMXMap struct {
+	Width      int `xml:"width,attr"`
+	Height     int `xml:"height,attr"`
+	TileWidth  int `xml:"tilewidth,attr"`
+	TileHeight int `xml:"tileheight,attr"`
+	Tilesets   []struct {
+		FirstGID   uint32 `xml:"firstgid,attr"`
+		Source     string `xml:"source,attr"`
+		TileWidth  int    `xml:"tilewidth,attr"`
+		TileHeight int    `xml:"tileheight,attr"`
+		TileCount  int    `xml:"tilecount,attr"`
+		Image      struct {
+			Source string `xml:"source,attr"`
+		} `xml:"image"`
+		Tiles []tiledTile `xml:"tile"`
+	} `xml:"tileset"`
+	Layers []struct {
+		Name string `xml:"name,attr"`
+		Data struct {
+			Encoding string `xml:"encoding,attr"`
+			Content  string `xml:",chardata"`
+		} `xml:"data"`
+	} `xml:"layer"`
+	ObjectGroups []struct {
+		Objects []tiledObject `xml:"object"`
+	} `xml:"objectgroup"`
+}
+
+func parseTiledJSON(data []byte) (*Level, error) {
+	var m tiledJSONMap
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	level := &Level{Width: m.Width, Height: m.Height, TileWidth: m.TileWidth, TileHeight: m.TileHeight}
+	for _, ts := range m.Tilesets {
+		if ts.Source != "" {
+			return nil, fmt.Errorf("external tileset %s is not supported, embed it in the map", ts.Source)
+		}
+		tileset, err := newLevelTileset(ts.FirstGID, ts.Image, ts.TileWidth, ts.TileHeight, ts.TileCount, ts.Tiles)
+		if err != nil {
+			return nil, err
+		}
+		level.Tilesets = append(level.Tilesets, tileset)
+	}
+
+	for _, layer := range m.Layers {
+		switch layer.Type {
+		case "tilelayer":
+			level.Layers = append(level.Layers, LevelLayer{Name: layer.Name, Data: layer.Data})
+		case "objectgroup":
+			if err := level.addObjects(layer.Objects); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return level, nil
+}
+
+func parseTiledTMX(data []byte) (*Level, error) {
+	var m tiledTMXMap
+	if err := xml.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	level := &Level{Width: m.Width, Height: m.Height, TileWidth: m.TileWidth, TileHeight: m.TileHeight}
+	for _, ts := range m.Tilesets {
+		if ts.Source != "" {
+			return nil, fmt.Errorf("external tileset %s is not supported, embed it in the map", ts.Source)
+		}
+		tileset, err := newLevelTileset(ts.FirstGID, ts.Image.Source, ts.TileWidth, ts.TileHeight, ts.TileCount, ts.Tiles)
+		if err != nil {
+			return nil, err
+		}
+		level.Tilesets = append(level.Tilesets, tileset)
+	}
+
+	for _, layer := range m.Layers {
+		if layer.Data.Encoding != "csv" {
+			return nil, fmt.Errorf("layer %q: only csv tile data is supported", layer.Name)
+		}
+		var gids []uint32
+		for _, field := range strings.Split(layer.Data.Content, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+			gid, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("layer %q: %v", layer.Name, err)
+			}
+			gids = append(gids, uint32(gid))
+		}
+		level.Layers = append(level.Layers, LevelLayer{Name: layer.Name, Data: gids})
+	}
+
+	for _, group := range m.ObjectGroups {
+		if err := level.addObjects(group.Objects); err != nil {
+			return nil, err
+		}
+	}
+
+	return level, nil
+}
+
+// newLevelTileset convert a Tiled tileset, picking up the tile weights
+func newLevelTileset(firstGID uint32, image string, tileWidth, tileHeight, tileCount int, tiles []tiledTile) (LevelTileset, error) {
+	tileset := LevelTileset{
+		FirstGID:   firstGID,
+		Image:      image,
+		TileWidth:  tileWidth,
+		TileHeight: tileHeight,
+		TileCount:  tileCount,
+		Weights:    make(map[int]int),
+	}
+	for _, tile := range tiles {
+		weight, ok, err := intProperty(tile.Properties, "weight")
+		if err != nil {
+			return tileset, err
+		}
+		if ok {
+			tileset.Weights[tile.ID] = weight
+		}
+	}
+	return tileset, nil
+}
+
+// addObjects add the spawn points, resource nodes and buildings among the
+// objects of an object layer
+func (l *Level) addObjects(objects []tiledObject) error {
+	for _, obj := range objects {
+		var err error
+		switch {
+		case obj.is(spawnType):
+			err = l.addSpawn(obj)
+		case obj.is(resourceType):
+			err = l.addResource(obj)
+		case obj.is(buildingType):
+			err = l.addBuilding(obj)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// is check the type of an object, Tiled 1.9 and later calls it the class
+func (obj tiledObject) is(kind string) bool {
+	return obj.Type == kind || obj.Class == kind
+}
+
+// addSpawn add a spawn point
+func (l *Level) addSpawn(obj tiledObject) error {
+	unit, ok := stringProperty(obj.Properties, "unit")
+	if !ok {
+		return fmt.Errorf("spawn at (%v, %v) has no unit type", obj.X, obj.Y)
+	}
+	team, _, err := intProperty(obj.Properties, "team")
+	if err != nil {
+		return err
+	}
+	l.Spawns = append(l.Spawns, SpawnPoint{Position: engo.Point{X: obj.X, Y: obj.Y}, Unit: unit, Team: Team(team)})
+	return nil
+}
+
+// addResource add a resource node, point objects get the default size
+func (l *Level) addResource(obj tiledObject) error {
+	amount, ok, err := intProperty(obj.Properties, "amount")
+	if err != nil {
+		return err
+	}
+	if !ok || amount <= 0 {
+		return fmt.Errorf("resource at (%v, %v) needs a positive amount", obj.X, obj.Y)
+	}
+	width, height := obj.Width, obj.Height
+	if width == 0 || height == 0 {
+		width, height = defaultResourceSize, defaultResourceSize
+	}
+	l.Resources = append(l.Resources, ResourcePoint{Position: engo.Point{X: obj.X, Y: obj.Y}, Width: width, Height: height, Amount: amount})
+	return nil
+}
+
+// addBuilding add a building a team starts with
+func (l *Level) addBuilding(obj tiledObject) error {
+	building, ok := stringProperty(obj.Properties, "building")
+	if !ok {
+		return fmt.Errorf("building at (%v, %v) has no building type", obj.X, obj.Y)
+	}
+	team, _, err := intProperty(obj.Properties, "team")
+	if err != nil {
+		return err
+	}
+	l.Buildings = append(l.Buildings, BuildingPoint{Position: engo.Point{X: obj.X, Y: obj.Y}, Building: building, Team: Team(team)})
+	return nil
+}
+
+// stringProperty look up a string property
+func stringProperty(props []tiledProperty, name string) (string, bool) {
+	for _, prop := range props {
+		if prop.Name != name {
+			continue
+		}
+		if v, ok := prop.Value.(string); ok {
+			return v, true
+		}
+		return prop.XMLValue, prop.Value == nil
+	}
+	return "", false
+}
+
+// intProperty look up an integer property
+func intProperty(props []tiledProperty, name string) (int, bool, error) {
+	for _, prop := range props {
+		if prop.Name != name {
+			continue
+		}
+		switch v := prop.Value.(type) {
+		case float64:
+			return int(v), true, nil
+		case nil:
+			i, err := strconv.Atoi(prop.XMLValue)
+			if err != nil {
+				return 0, false, fmt.Errorf("property %s: %v", name, err)
+			}
+			return i, true, nil
+		default:
+			return 0, false, fmt.Errorf("property %s is not a number", name)
+		}
+	}
+	return 0, false, nil
+}
@@ -0,0 +1,455 @@
+package systems
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lockstep settings
+const (
+	// Ticks between issuing a command and carrying it out, the time the batch
+	// has to reach every player
+	lockstepDelay = 3
+	// How long the game waits for a player before saying so
+	stallWarning = 2 * time.Second
+	// How long the host waits for a player before dropping it from the game
+	dropAfter = 20 * time.Second
+)
+
+// netConn connection to another player, exchanging JSON messages
+type netConn struct {
+	conn net.Conn
+	dec  *json.Decoder
+
+	mu  sync.Mutex // guards enc
+	enc *json.Encoder
+}
+
+// netMessage message between the players, only one of its fields is set
+type netMessage struct {
+	Welcome *netWelcome `json:"welcome,omitempty"`
+	Batch   *netBatch   `json:"batch,omitempty"`
+	Turn    *netTurn    `json:"turn,omitempty"`
+}
+
+// netWelcome sent by the host to a player that joins
+type netWelcome struct {
+	Team      Team   `json:"team"`
+	Players   int    `json:"players"`
+	Level     string `json:"level"`
+	UnitTypes string `json:"unitTypes"`
+}
+
+// netBatch commands a player issued for a tick, with the checksum of its state
+// at an earlier tick
+type netBatch struct {
+	Tick     int       `json:"tick"`
+	Commands []Command `json:"commands"`
+	SumTick  int       `json:"sumTick"`
+	Sum      uint32    `json:"sum"`
+}
+
+// netTurn commands of every player for a tick, sent by the host
+type netTurn struct {
+	Tick     int       `json:"tick"`
+	Commands []Command `json:"commands"`
+	Left     []Team    `json:"left,omitempty"`   // players that left the game
+	Desync   []Team    `json:"desync,omitempty"` // players whose state differs from the host's
+	SumTick  int       `json:"sumTick"`
+}
+
+// newNetConn wrap a connection
+func newNetConn(conn net.Conn) *netConn {
+	return &netConn{conn: conn, dec: json.NewDecoder(conn), enc: json.NewEncoder(conn)}
+}
+
+// send a message
+func (c *netConn) send(msg netMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.enc.Encode(msg)
+}
+
+// lockstepPeer player connected to the host
+type lockstepPeer struct {
+	team Team
+	conn *netConn
+	gone bool
+}
+
+// Lockstep network game in deterministic lockstep. Every player runs the
+// whole simulation; only commands travel. A command issued at tick t is sent
+// to the host in the batch of tick t+lockstepDelay, the host merges the
+// batches of every player into the turn of that tick and sends it back to
+// all of them. A tick only runs once its turn is there, so a slow player
+// holds the game back instead of falling out of step.
+//
+// Every batch carries a checksum of the state of the player, which the host
+// compares to its own to detect desyncs.
+type Lockstep struct {
+	team    Team
+	players int
+	host    bool
+	spawner *UnitSpawner
+
+	mu         sync.Mutex
+	turns      map[int]netTurn // merged turns waiting to be carried out
+	sent       int             // ticks the batches were sent for
+	local      []Command       // issued since the last batch
+	err        error           // connection to the host lost
+	closed     bool
+	desynced   bool // a desync was reported, the games have gone apart for good
+	stallSince time.Time
+	warned     bool
+
+	// Host only
+	listener net.Listener
+	peers    []*lockstepPeer
+	batches  map[int]map[Team]netBatch // received, by tick and player
+	sums     map[int]uint32            // own checksums, by tick
+	merged   int                       // next tick to merge
+	left     []Team                    // players that left since the last merged turn
+
+	// Others only
+	conn *netConn
+}
+
+// HostGame host a game on addr, such as ":7777", and wait for the other
+// players to join. The host plays team 1, the others team 2 and up in the
+// order they join.
+func HostGame(addr string, players int, level string) (*Lockstep, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return hostOn(listener, players, level)
+}
+
+// hostOn host a game on a listener and wait for the other players to join
+func hostOn(listener net.Listener, players int, level string) (*Lockstep, error) {
+	ls := newLockstep(1, players)
+	ls.host = true
+	ls.listener = listener
+	ls.batches = make(map[int]map[Team]netBatch)
+	ls.sums = make(map[int]uint32)
+	ls.merged = lockstepDelay
+
+	log.Printf("Waiting on %s for %d more players", listener.Addr(), players-1)
+	for len(ls.peers) < players-1 {
+		conn, err := listener.Accept()
+		if err != nil {
+			ls.Close()
+			return nil, err
+		}
+		peer := &lockstepPeer{team: Team(len(ls.peers) + 2), conn: newNetConn(conn)}
+		welcome := &netWelcome{Team: peer.team, Players: players, Level: level, UnitTypes: UnitTypesURL}
+		if err := peer.conn.send(netMessage{Welcome: welcome}); err != nil {
+			log.Println(err)
+			conn.Close()
+			continue
+		}
+		log.Printf("Team %d joined from %s", peer.team, conn.RemoteAddr())
+		ls.peers = append(ls.peers, peer)
+	}
+	for _, peer := range ls.peers {
+		go ls.readPeer(peer)
+	}
+	return ls, nil
+}
+
+// JoinGame join a game hosted at addr, such as "localhost:7777". The game must
+// be on the same level.
+func JoinGame(addr string, level string) (*Lockstep, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := newNetConn(conn)
+	var msg netMessage
+	if err := c.dec.Decode(&msg); err != nil || msg.Welcome == nil {
+		conn.Close()
+		return nil, fmt.Errorf("joining %s: no welcome from the host", addr)
+	}
+	welcome := msg.Welcome
+	if welcome.Level != level || welcome.UnitTypes != UnitTypesURL {
+		conn.Close()
+		return nil, fmt.Errorf("joining %s: the host plays %s with %s, not %s with %s", addr, welcome.Level, welcome.UnitTypes, level, UnitTypesURL)
+	}
+	ls := newLockstep(welcome.Team, welcome.Players)
+	ls.conn = c
+	log.Printf("Joined %s as team %d of %d", addr, ls.team, ls.players)
+	go ls.readHost()
+	return ls, nil
+}
+
+// newLockstep a game of the given number of players, played as team
+func newLockstep(team Team, players int) *Lockstep {
+	return &Lockstep{team: team, players: players, turns: make(map[int]netTurn)}
+}
+
+// Team the team of the local player
+func (ls *Lockstep) Team() Team {
+	return ls.team
+}
+
+// Attach let the lockstep drive a spawner, commands issued to the spawner
+// are sent to the other players
+func (ls *Lockstep) Attach(us *UnitSpawner) {
+	ls.spawner = us
+	us.Source = ls
+	us.Player = ls.team
+}
+
+// Send queue a command issued by the local player for the next batch
+func (ls *Lockstep) Send(cmd Command) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.local = append(ls.local, cmd)
+}
+
+// Commands the turn of a tick, false while it has not arrived. The first call
+// for a tick sends the batch of the local player.
+func (ls *Lockstep) Commands(tick int) ([]Command, bool) {
+	if tick >= ls.sent {
+		batch := netBatch{Tick: tick + lockstepDelay, SumTick: tick}
+		if ls.spawner != nil {
+			batch.Sum = ls.spawner.Checksum()
+		}
+		ls.mu.Lock()
+		batch.Commands, ls.local = ls.local, nil
+		ls.mu.Unlock()
+		ls.sendBatch(batch)
+		ls.sent = tick + 1
+	}
+	if tick < lockstepDelay {
+		// Nobody could issue anything for the first ticks
+		return nil, true
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	turn, ok := ls.turns[tick]
+	if !ok {
+		ls.stall()
+		return nil, false
+	}
+	delete(ls.turns, tick)
+	ls.stallSince, ls.warned = time.Time{}, false
+	for _, team := range turn.Left {
+		log.Printf("Team %d left the game", team)
+	}
+	if len(turn.Desync) > 0 && !ls.desynced {
+		ls.desynced = true
+		log.Printf("Desync at tick %d: the state of teams %v differs from the host's", turn.SumTick, turn.Desync)
+	}
+	return turn.Commands, true
+}
+
+// stall note that the game waits for a turn, warn when it takes long and drop
+// the players the host waits for too long
+func (ls *Lockstep) stall() {
+	if ls.stallSince.IsZero() {
+		ls.stallSince = time.Now()
+	}
+	waited := time.Since(ls.stallSince)
+	if waited > stallWarning && !ls.warned {
+		log.Println("Waiting for", ls.waitingFor())
+		ls.warned = true
+	}
+	if ls.host && waited > dropAfter {
+		for _, peer := range ls.missing() {
+			log.Printf("Dropping team %d, it has not answered for %v", peer.team, dropAfter)
+			// The reader of the peer sees the connection close and drops it
+			peer.conn.conn.Close()
+		}
+	}
+}
+
+// waitingFor describe who the game waits for
+func (ls *Lockstep) waitingFor() string {
+	if !ls.host {
+		if ls.err != nil {
+			return fmt.Sprintf("the host, the connection was lost: %v", ls.err)
+		}
+		return "the host"
+	}
+	var teams []string
+	for _, peer := range ls.missing() {
+		teams = append(teams, fmt.Sprintf("team %d", peer.team))
+	}
+	return strings.Join(teams, ", ")
+}
+
+// missing the players that have not sent their batch of the tick the host
+// merges next
+func (ls *Lockstep) missing() []*lockstepPeer {
+	var peers []*lockstepPeer
+	for _, peer := range ls.peers {
+		if _, ok := ls.batches[ls.merged][peer.team]; !ok && !peer.gone {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// sendBatch hand a batch to the host, the host takes its own right away
+func (ls *Lockstep) sendBatch(batch netBatch) {
+	if !ls.host {
+		if err := ls.conn.send(netMessage{Batch: &batch}); err != nil {
+			ls.lost(err)
+		}
+		return
+	}
+	ls.mu.Lock()
+	ls.sums[batch.SumTick] = batch.Sum
+	ls.receive(ls.team, batch)
+	turns, conns := ls.merge()
+	ls.mu.Unlock()
+	broadcast(turns, conns)
+}
+
+// readPeer receive the batches of a player until it leaves
+func (ls *Lockstep) readPeer(peer *lockstepPeer) {
+	for {
+		var msg netMessage
+		if err := peer.conn.dec.Decode(&msg); err != nil {
+			break
+		}
+		if msg.Batch == nil {
+			continue
+		}
+		ls.mu.Lock()
+		ls.receive(peer.team, *msg.Batch)
+		turns, conns := ls.merge()
+		ls.mu.Unlock()
+		broadcast(turns, conns)
+	}
+	peer.conn.conn.Close()
+
+	ls.mu.Lock()
+	peer.gone = true
+	ls.left = append(ls.left, peer.team)
+	turns, conns := ls.merge()
+	ls.mu.Unlock()
+	broadcast(turns, conns)
+}
+
+// receive store the batch of a player until the turn of its tick is merged
+func (ls *Lockstep) receive(team Team, batch netBatch) {
+	if batch.Tick < ls.merged {
+		return
+	}
+	if ls.batches[batch.Tick] == nil {
+		ls.batches[batch.Tick] = make(map[Team]netBatch)
+	}
+	ls.batches[batch.Tick][team] = batch
+}
+
+// merge turn the batches into turns for every tick all players have sent
+// theirs for, returning the turns and the connections to send them on
+func (ls *Lockstep) merge() ([]netTurn, []*netConn) {
+	var turns []netTurn
+	for {
+		batches := ls.batches[ls.merged]
+		if _, ok := batches[ls.team]; !ok || len(ls.missing()) > 0 {
+			break
+		}
+		teams := make([]Team, 0, len(batches))
+		for team := range batches {
+			teams = append(teams, team)
+		}
+		sort.Slice(teams, func(i, j int) bool { return teams[i] < teams[j] })
+
+		turn := netTurn{Tick: ls.merged, Commands: []Command{}, Left: ls.left, SumTick: batches[ls.team].SumTick}
+		for _, team := range teams {
+			batch := batches[team]
+			for _, cmd := range batch.Commands {
+				// A peer only orders, trains and builds for its own team
+				cmd.Team = team
+				turn.Commands = append(turn.Commands, cmd)
+			}
+			if batch.Sum != ls.sums[batch.SumTick] {
+				turn.Desync = append(turn.Desync, team)
+			}
+		}
+		delete(ls.sums, turn.SumTick)
+		delete(ls.batches, ls.merged)
+		ls.left = nil
+		ls.turns[turn.Tick] = turn
+		turns = append(turns, turn)
+		ls.merged++
+	}
+
+	var conns []*netConn
+	if len(turns) > 0 {
+		for _, peer := range ls.peers {
+			if !peer.gone {
+				conns = append(conns, peer.conn)
+			}
+		}
+	}
+	return turns, conns
+}
+
+// broadcast send turns to the other players. A player that can not be
+// reached is dropped once its reader notices.
+func broadcast(turns []netTurn, conns []*netConn) {
+	for _, conn := range conns {
+		for i := range turns {
+			if err := conn.send(netMessage{Turn: &turns[i]}); err != nil {
+				conn.conn.Close()
+				break
+			}
+		}
+	}
+}
+
+// readHost receive the turns from the host until the connection is lost
+func (ls *Lockstep) readHost() {
+	for {
+		var msg netMessage
+		if err := ls.conn.dec.Decode(&msg); err != nil {
+			ls.lost(err)
+			return
+		}
+		if msg.Turn != nil {
+			ls.mu.Lock()
+			ls.turns[msg.Turn.Tick] = *msg.Turn
+			ls.mu.Unlock()
+		}
+	}
+}
+
+// lost note that the connection to the host is gone, the game can not go on
+func (ls *Lockstep) lost(err error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.err == nil && !ls.closed {
+		ls.err = err
+		log.Println("Lost the connection to the host:", err)
+	}
+}
+
+// Close leave the game
+func (ls *Lockstep) Close() {
+	ls.mu.Lock()
+	ls.closed = true
+	ls.mu.Unlock()
+	if ls.listener != nil {
+		ls.listener.Close()
+	}
+	if ls.conn != nil {
+		ls.conn.conn.Close()
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	for _, peer := range ls.peers {
+		peer.conn.conn.Close()
+	}
+}
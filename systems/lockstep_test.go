@@ -0,0 +1,137 @@
+package systems
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/EngoEngine/engo"
+)
+
+// lockstepSpawner a headless spawner on an open grid driven by a lockstep
+// game, with a fish and a blob for both teams
+func lockstepSpawner(t *testing.T, ls *Lockstep, types *UnitTypes) *UnitSpawner {
+	us := &UnitSpawner{Types: types, Headless: true}
+	us.New(nil)
+	ls.Attach(us)
+	for _, unit := range []ScenarioUnit{
+		{Type: "fish", Team: 1, X: 40, Y: 40},
+		{Type: "blob", Team: 1, X: 120, Y: 40},
+		{Type: "fish", Team: 2, X: 600, Y: 600},
+		{Type: "blob", Team: 2, X: 680, Y: 600},
+	} {
+		if _, err := us.SpawnUnitAtLocation(unit.X, unit.Y, unit.Type, unit.Team); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return us
+}
+
+func TestLockstepLoopback(t *testing.T) {
+	const ticks = 120
+
+	types, err := LoadUnitTypes(UnitTypesURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosted := make(chan *Lockstep)
+	go func() {
+		host, err := hostOn(listener, 2, "")
+		if err != nil {
+			t.Error(err)
+		}
+		hosted <- host
+	}()
+	peer, err := JoinGame(listener.Addr().String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	host := <-hosted
+	if host == nil {
+		return
+	}
+	defer host.Close()
+
+	hostGame := lockstepSpawner(t, host, types)
+	defer hostGame.Stop()
+	peerGame := lockstepSpawner(t, peer, types)
+	defer peerGame.Stop()
+
+	// Both players order their units around, at different ticks, and the peer
+	// tries to order a unit of the host
+	orders := map[*UnitSpawner]map[int]Command{
+		hostGame: {
+			5:  {Units: []UnitID{1, 2}, Kind: OrderMove, Target: ToFixedPoint(engo.Point{X: 400, Y: 300}), Formation: FormationLine},
+			40: {Units: []UnitID{1}, Kind: OrderAttack, Enemy: 3},
+		},
+		peerGame: {
+			10: {Units: []UnitID{3, 4}, Kind: OrderAttackMove, Target: ToFixedPoint(engo.Point{X: 100, Y: 100})},
+			20: {Units: []UnitID{2}, Kind: OrderMove, Target: ToFixedPoint(engo.Point{X: 700, Y: 100})},
+			60: {Units: []UnitID{4}, Kind: OrderStop},
+		},
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for hostGame.Ticks() < ticks || peerGame.Ticks() < ticks {
+		if time.Now().After(deadline) {
+			t.Fatalf("stalled at tick %d on the host and %d on the peer", hostGame.Ticks(), peerGame.Ticks())
+		}
+		progress := false
+		for _, us := range []*UnitSpawner{hostGame, peerGame} {
+			if us.Ticks() >= ticks {
+				continue
+			}
+			if cmd, ok := orders[us][us.Ticks()]; ok {
+				us.Issue(cmd)
+				delete(orders[us], us.Ticks())
+			}
+			if us.Tick() {
+				progress = true
+			}
+		}
+		if !progress {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// The commands of the peer are carried out by the host and the other way
+	// around, the order for the unit of the host is not
+	blob := peerGame.Unit(2)
+	switch {
+	case blob == nil:
+		t.Error("the blob of the host died on the peer")
+	case blob.moving():
+		t.Error("the blob of the host is still moving on the peer")
+	case blob.pos.Dist(ToFixedPoint(engo.Point{X: 400, Y: 300})) > FixedFromInt(64):
+		t.Errorf("the blob of the host ended at %v on the peer, away from where the host sent it", blob.pos.Engo())
+	}
+	if peerGame.Unit(3) != nil {
+		t.Error("the fish of the peer survived the attack of the host on the peer")
+	}
+	start, goal := ToFixedPoint(engo.Point{X: 712, Y: 632}), ToFixedPoint(engo.Point{X: 100, Y: 100})
+	peerBlob := hostGame.Unit(4)
+	switch {
+	case peerBlob == nil:
+		t.Error("the blob of the peer died on the host")
+	case peerBlob.moving():
+		t.Error("the blob of the peer did not stop on the host")
+	case peerBlob.pos.Dist(goal) > start.Dist(goal)-FixedFromInt(64):
+		t.Errorf("the blob of the peer ended at %v on the host, it did not attack-move", peerBlob.pos.Engo())
+	}
+	for _, ls := range []*Lockstep{host, peer} {
+		ls.mu.Lock()
+		desynced := ls.desynced
+		ls.mu.Unlock()
+		if desynced {
+			t.Errorf("team %d reported a desync", ls.team)
+		}
+	}
+	if hostGame.Checksum() != peerGame.Checksum() {
+		t.Errorf("checksums differ at tick %d: %x on the host, %x on the peer", ticks, hostGame.Checksum(), peerGame.Checksum())
+	}
+}
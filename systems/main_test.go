@@ -0,0 +1,25 @@
+package systems
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Tests run in the package directory, the assets are in the repository root
+	AssetRoot = filepath.Join("..", "assets")
+	os.Exit(m.Run())
+}
+
+// headlessSpawner a headless spawner on an open grid with the unit types of
+// the game
+func headlessSpawner(t *testing.T) *UnitSpawner {
+	types, err := LoadUnitTypes(UnitTypesURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	us := &UnitSpawner{Types: types, Headless: true}
+	us.New(nil)
+	return us
+}
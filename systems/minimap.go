@@ -0,0 +1,267 @@
+package systems
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/EngoEngine/ecs"
+	"github.com/EngoEngine/engo"
+	"github.com/EngoEngine/engo/common"
+)
+
+// Minimap settings
+const (
+	// Size of the longer side of the minimap, in pixels on screen
+	defaultMinimapSize = 200
+	// Pixels between the minimap and the window corner
+	minimapMargin = 10
+	// Seconds between redraws of the minimap
+	minimapRefresh = 0.2
+)
+
+// Colours of the minimap
+var (
+	minimapGround     = color.NRGBA{60, 90, 60, 255}
+	minimapBlocked    = color.NRGBA{20, 20, 20, 255}
+	minimapSlow       = color.NRGBA{110, 90, 50, 255} // tiles with a positive weight
+	minimapUnexplored = color.NRGBA{0, 0, 0, 255}
+	minimapView       = color.RGBA{255, 255, 255, 255}
+)
+
+// minimapPanel entity the minimap is drawn on
+type minimapPanel struct {
+	ecs.BasicEntity
+	common.RenderComponent
+	common.SpaceComponent
+	common.MouseComponent
+}
+
+// Minimap system that draws the level, the units and the view of the camera
+// in the bottom left corner of the window. Left click or drag pans the camera
+// there, right click gives the selected units the armed order there, like a
+// right click in the world.
+type Minimap struct {
+	// Size of the longer side, defaultMinimapSize when left at zero
+	Size float32
+
+	spawner *UnitSpawner
+	mouse   *MouseFollower
+	camera  *common.CameraSystem
+
+	panel   minimapPanel
+	view    minimapPanel // camera rectangle
+	scale   float32      // screen pixels per pathing tile
+	image   *image.NRGBA
+	terrain []color.NRGBA // colours of the tiles of grid at version
+	grid    Grid
+	version uint64
+	clock   float32 // seconds until the next redraw
+}
+
+// New create the minimap panel
+func (m *Minimap) New(w *ecs.World) {
+	if m.Size == 0 {
+		m.Size = defaultMinimapSize
+	}
+	m.camera = findCamera(w)
+	for _, system := range w.Systems() {
+		switch sys := system.(type) {
+		case *UnitSpawner:
+			m.spawner = sys
+		case *MouseFollower:
+			m.mouse = sys
+		}
+	}
+	if m.spawner == nil {
+		return
+	}
+
+	rows, cols := m.spawner.ast.(Grid).Size()
+	m.scale = m.Size / float32(rows)
+	if cols > rows {
+		m.scale = m.Size / float32(cols)
+	}
+	m.image = image.NewNRGBA(image.Rect(0, 0, rows, cols))
+
+	m.panel = minimapPanel{BasicEntity: ecs.NewBasic()}
+	m.panel.SpaceComponent = common.SpaceComponent{
+		Position: engo.Point{X: minimapMargin, Y: engo.GameHeight() - float32(cols)*m.scale - minimapMargin},
+		Width:    float32(rows) * m.scale,
+		Height:   float32(cols) * m.scale,
+	}
+	m.panel.RenderComponent = common.RenderComponent{Scale: engo.Point{X: m.scale, Y: m.scale}}
+	m.panel.RenderComponent.SetShader(common.HUDShader)
+	m.panel.RenderComponent.SetZIndex(10)
+
+	m.view = minimapPanel{BasicEntity: ecs.NewBasic()}
+	m.view.RenderComponent = common.RenderComponent{
+		Drawable: common.Rectangle{BorderWidth: 1, BorderColor: minimapView},
+		Color:    color.Transparent,
+	}
+	m.view.RenderComponent.SetShader(common.HUDShader)
+	m.view.RenderComponent.SetZIndex(11)
+
+	for _, system := range w.Systems() {
+		switch sys := system.(type) {
+		case *common.RenderSystem:
+			sys.Add(&m.panel.BasicEntity, &m.panel.RenderComponent, &m.panel.SpaceComponent)
+			sys.Add(&m.view.BasicEntity, &m.view.RenderComponent, &m.view.SpaceComponent)
+		case *common.MouseSystem:
+			sys.Add(&m.panel.BasicEntity, &m.panel.MouseComponent, &m.panel.SpaceComponent, &m.panel.RenderComponent)
+		}
+	}
+	m.draw()
+}
+
+// Remove does nothing, the minimap stays for the whole scene
+func (*Minimap) Remove(ecs.BasicEntity) {}
+
+// Hovered check if the mouse is over the minimap, clicks there are not meant
+// for the world
+func (m *Minimap) Hovered() bool {
+	return m.panel.MouseComponent.Hovered || m.panel.MouseComponent.Dragged
+}
+
+// toWorld the world point under a point on the minimap, in window coordinates
+func (m *Minimap) toWorld(p engo.Point) engo.Point {
+	x := (p.X - m.panel.Position.X) / m.scale * discreteStep
+	y := (p.Y - m.panel.Position.Y) / m.scale * discreteStep
+	return engo.Point{
+		X: clamp(x, 0, m.panel.Width/m.scale*discreteStep),
+		Y: clamp(y, 0, m.panel.Height/m.scale*discreteStep),
+	}
+}
+
+// clamp keep v in [min, max]
+func clamp(v, min, max float32) float32 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// Update handle clicks and redraw the minimap now and then
+func (m *Minimap) Update(dt float32) {
+	if m.spawner == nil {
+		return
+	}
+	mouse := m.panel.MouseComponent
+	target := m.toWorld(windowToGame(engo.Point{X: engo.Input.Mouse.X, Y: engo.Input.Mouse.Y}))
+	switch {
+	case mouse.Clicked || mouse.Dragged:
+		CenterCamera(target)
+	case mouse.RightClicked && m.mouse != nil:
+		m.mouse.orderAt(target)
+	}
+
+	m.placeView()
+	m.clock -= dt
+	if m.clock <= 0 {
+		m.clock = minimapRefresh
+		m.draw()
+	}
+}
+
+// placeView frame the part of the world the camera shows
+func (m *Minimap) placeView() {
+	if m.camera == nil {
+		return
+	}
+	z := m.camera.Z()
+	ratio := m.scale / discreteStep
+	width, height := engo.GameWidth()*z, engo.GameHeight()*z
+	m.view.SpaceComponent = common.SpaceComponent{
+		Position: engo.Point{
+			X: m.panel.Position.X + (m.camera.X()-width/2)*ratio,
+			Y: m.panel.Position.Y + (m.camera.Y()-height/2)*ratio,
+		},
+		Width:  width * ratio,
+		Height: height * ratio,
+	}
+}
+
+// draw the tiles, resource nodes, buildings and units the player knows about
+func (m *Minimap) draw() {
+	us := m.spawner
+	grid := us.ast.(Grid)
+	rows, cols := grid.Size()
+	if version := grid.Version(); grid != m.grid || version != m.version {
+		// A loaded game comes with a grid of its own
+		m.grid, m.version = grid, version
+		m.terrain = make([]color.NRGBA, rows*cols)
+		for x := 0; x < rows; x++ {
+			for y := 0; y < cols; y++ {
+				switch weight := grid.Weight(Point{X: x, Y: y}); {
+				case weight == -1:
+					m.terrain[x*cols+y] = minimapBlocked
+				case weight > 0:
+					m.terrain[x*cols+y] = minimapSlow
+				default:
+					m.terrain[x*cols+y] = minimapGround
+				}
+			}
+		}
+	}
+
+	visions := us.playerVisions()
+	for x := 0; x < rows; x++ {
+		for y := 0; y < cols; y++ {
+			c := m.terrain[x*cols+y]
+			if us.Fog {
+				switch sees(visions, Point{X: x, Y: y}) {
+				case Unexplored:
+					c = minimapUnexplored
+				case Explored:
+					c = color.NRGBA{c.R / 2, c.G / 2, c.B / 2, 255}
+				}
+			}
+			m.image.SetNRGBA(x, y, c)
+		}
+	}
+
+	for _, node := range us.nodes {
+		if node.Amount > 0 && !(us.Fog && node.RenderComponent.Hidden) {
+			m.fill(node.rect, resourceColor)
+		}
+	}
+	for _, b := range us.buildings {
+		if !(us.Fog && b.hidden) {
+			m.fill(b.rect, b.team.Color())
+		}
+	}
+
+	for _, unit := range us.AliveUnits {
+		if us.Fog && unit.hidden {
+			continue
+		}
+		// The dot covers the tiles the body of the unit covers
+		tile := FixedToPathing(unit.pos)
+		half := unit.radius().Int() / discreteStep
+		c := unit.team.Color()
+		if unit.selected {
+			c = color.RGBA{255, 255, 255, 255}
+		}
+		for x := tile.X - half; x <= tile.X+half; x++ {
+			for y := tile.Y - half; y <= tile.Y+half; y++ {
+				m.image.Set(x, y, c)
+			}
+		}
+	}
+
+	if m.panel.Drawable != nil {
+		m.panel.Drawable.Close()
+	}
+	m.panel.Drawable = common.NewTextureSingle(common.NewImageObject(m.image))
+}
+
+// fill colour the tiles of a footprint
+func (m *Minimap) fill(rect tileRect, c color.Color) {
+	for x := rect.x0; x < rect.x1; x++ {
+		for y := rect.y0; y < rect.y1; y++ {
+			m.image.Set(x, y, c)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package systems
+
+import (
+	"log"
+	"math"
+)
+
+// StuckReason why a unit gave up on getting where it was going
+type StuckReason int
+
+// Reasons for a unit to give up
+const (
+	NotStuck     StuckReason = iota // got there, or still on the way
+	StuckNoPath                     // no path leads there
+	StuckBlocked                    // new paths kept running into blocked tiles
+	stuckReasonCount
+)
+
+// stuckNames names of the reasons, as they are reported
+var stuckNames = [stuckReasonCount]string{"", "no path", "blocked"}
+
+func (r StuckReason) String() string {
+	if r < 0 || r >= stuckReasonCount {
+		return "unknown"
+	}
+	return stuckNames[r]
+}
+
+// Blocked tile settings
+const (
+	// Ticks a unit waits in front of a blocked tile before it searches a new
+	// path, the tile may free up in the meantime
+	blockedWait = 10
+	// Paths a unit searches in a row without reaching a waypoint before it
+	// gives up
+	maxRepaths = 3
+	// pathVersion of a path that is searched again at the next check
+	stalePath = math.MaxUint64
+)
+
+// Stuck why the unit last gave up on getting where it was ordered to,
+// NotStuck if it did not
+func (unit *BasicUnit) Stuck() StuckReason {
+	return unit.stuck
+}
+
+// invalidatePaths search a new path for the units whose path crosses a tile
+// that changed since it was planned, run once the tiles changed for the tick
+func (us *UnitSpawner) invalidatePaths() {
+	grid, ok := us.ast.(Grid)
+	if !ok {
+		return
+	}
+	version := grid.Version()
+	for _, unit := range us.AliveUnits {
+		if us.paths.Pending(unit) {
+			continue
+		}
+		changed := unit.pathChanged(grid)
+		unit.pathVersion = version
+		if changed {
+			us.paths.Request(unit, PathingToFixed(unit.goal))
+		}
+	}
+}
+
+// pathChanged check if the rest of the path of the unit crosses a tile that
+// changed since the path was last checked
+func (unit *BasicUnit) pathChanged(grid Grid) bool {
+	if unit.path == nil || unit.pathVersion == grid.Version() {
+		return false
+	}
+	tiles, ok := grid.Changed(unit.pathVersion)
+	return !ok || unit.pathCrosses(tiles)
+}
+
+// pathCrosses check if the rest of the path of the unit touches one of the
+// tiles, the unit walks straight from one waypoint to the next
+func (unit *BasicUnit) pathCrosses(tiles []Point) bool {
+	changed := make(map[Point]bool, len(tiles))
+	for _, p := range tiles {
+		changed[p] = true
+	}
+	hit := func(p Point) bool { return changed[p] }
+	from := FixedToPathing(unit.pos)
+	for p := unit.path; p != nil; p = p.Parent {
+		if lineTouches(from, p.Point, hit) {
+			return true
+		}
+		from = p.Point
+	}
+	return false
+}
+
+// blocked handle a unit whose next step is onto a blocked tile. It waits for
+// the path it was already promised, otherwise blockedWait ticks before it
+// searches one itself, and gives up after maxRepaths searches.
+func (us *UnitSpawner) blocked(unit *BasicUnit) {
+	if us.paths.Pending(unit) {
+		return
+	}
+	unit.blockedFor++
+	if unit.blockedFor < blockedWait {
+		return
+	}
+	unit.blockedFor = 0
+	if unit.repaths == maxRepaths {
+		us.giveUp(unit, StuckBlocked)
+		return
+	}
+	unit.repaths++
+	us.paths.Request(unit, PathingToFixed(unit.goal))
+}
+
+// giveUp stop a unit that can not get where it is going and report why
+func (us *UnitSpawner) giveUp(unit *BasicUnit, reason StuckReason) {
+	unit.stop()
+	unit.pace = 0
+	unit.repaths = 0
+	unit.stuck = reason
+	log.Printf("unit %d gave up reaching %v: %v", unit.id, unit.goal, reason)
+}
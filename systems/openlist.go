@@ -0,0 +1,99 @@
+package systems
+
+import "container/heap"
+
+// openList priority queue of PathPoints still to be expanded by FindPath.
+// It is a binary min-heap ordered on Weight. Every entry keeps its own heap
+// position and is indexed by Point, so a point that is reached again with a
+// lower weight can be updated in place (decrease-key) instead of being
+// inserted twice.
+type openList struct {
+	items []*PathPoint
+	index map[Point]*PathPoint
+}
+
+func newOpenList() *openList {
+	return &openList{index: make(map[Point]*PathPoint)}
+}
+
+// Len implements heap.Interface
+func (ol *openList) Len() int { return len(ol.items) }
+
+// Less implements heap.Interface. Ties on Weight are broken in favour of the
+// point that has travelled furthest, and after that on the point itself, so
+// the search order never depends on map iteration.
+func (ol *openList) Less(i, j int) bool {
+	a, b := ol.items[i], ol.items[j]
+	if a.Weight != b.Weight {
+		return a.Weight < b.Weight
+	}
+	if a.DistTraveled != b.DistTraveled {
+		return a.DistTraveled > b.DistTraveled
+	}
+	if a.X != b.X {
+		return a.X < b.X
+	}
+	return a.Y < b.Y
+}
+
+// Swap implements heap.Interface
+func (ol *openList) Swap(i, j int) {
+	ol.items[i], ol.items[j] = ol.items[j], ol.items[i]
+	ol.items[i].heapIndex = i
+	ol.items[j].heapIndex = j
+}
+
+// Push implements heap.Interface, use push instead
+func (ol *openList) Push(x interface{}) {
+	p := x.(*PathPoint)
+	p.heapIndex = len(ol.items)
+	ol.index[p.Point] = p
+	ol.items = append(ol.items, p)
+}
+
+// Pop implements heap.Interface, use popMin instead
+func (ol *openList) Pop() interface{} {
+	n := len(ol.items) - 1
+	p := ol.items[n]
+	ol.items[n] = nil
+	ol.items = ol.items[:n]
+	delete(ol.index, p.Point)
+	return p
+}
+
+// push add a point to the open list
+func (ol *openList) push(p *PathPoint) {
+	heap.Push(ol, p)
+}
+
+// peekMin return the point with the lowest weight without removing it, or nil
+func (ol *openList) peekMin() *PathPoint {
+	if len(ol.items) == 0 {
+		return nil
+	}
+	return ol.items[0]
+}
+
+// popMin remove and return the point with the lowest weight, or nil
+func (ol *openList) popMin() *PathPoint {
+	if len(ol.items) == 0 {
+		return nil
+	}
+	return heap.Pop(ol).(*PathPoint)
+}
+
+// get the open entry for a point, if any
+func (ol *openList) get(p Point) (*PathPoint, bool) {
+	existing, ok := ol.index[p]
+	return existing, ok
+}
+
+// decrease replace the open entry for candidate.Point with candidate, which
+// must have a lower weight, and restore the heap order
+func (ol *openList) decrease(candidate *PathPoint) {
+	i := ol.index[candidate.Point].heapIndex
+	candidate.heapIndex = i
+	ol.items[i] = candidate
+	ol.index[candidate.Point] = candidate
+	heap.Fix(ol, i)
+}
@@ -0,0 +1,374 @@
+package systems
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+
+	"github.com/EngoEngine/ecs"
+	"github.com/EngoEngine/engo"
+	"github.com/EngoEngine/engo/common"
+)
+
+// OrderKind what a unit is ordered to do
+type OrderKind int
+
+// Orders a unit can be given, followed by the orders for buildings
+const (
+	OrderMove       OrderKind = iota // walk to Target
+	OrderAttackMove                  // walk to Target, fighting enemies on the way
+	OrderAttack                      // chase and attack Unit, or Building when there is no Unit
+	OrderPatrol                      // attack-move back and forth between where the order starts and Target
+	OrderHold                        // stay put, only attacking enemies in range
+	OrderStop                        // drop everything
+	OrderGather                      // harvest the resource node at Target and bring the resources back
+	OrderBuild                       // place a building of Type with its top left corner at Target
+	OrderTrain                       // queue a unit of Type in Building
+	OrderRally                       // send the units Building trains to Target
+	orderKindCount
+)
+
+// orderNames names of the order kinds, as they are written in files
+var orderNames = [orderKindCount]string{"move", "attack-move", "attack", "patrol", "hold", "stop", "gather", "build", "train", "rally"}
+
+func (k OrderKind) String() string {
+	if k < 0 || k >= orderKindCount {
+		return "unknown"
+	}
+	return orderNames[k]
+}
+
+// MarshalText write the order kind by name
+func (k OrderKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// UnmarshalText read an order kind by name
+func (k *OrderKind) UnmarshalText(text []byte) error {
+	for i, name := range orderNames {
+		if name == string(text) {
+			*k = OrderKind(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown order %q", text)
+}
+
+// Order entry in the order queue of a unit
+type Order struct {
+	Kind   OrderKind
+	Target FixedPoint
+	Unit   *BasicUnit
+
+	building *Building     // building to attack
+	node     *ResourceNode // node to gather, nil once there is nothing left
+	pace     Fixed         // group speed
+	from     FixedPoint    // where the unit starts the order, the other end of a patrol
+	started  bool
+}
+
+// hasTarget check if the order leads to a point, that can be drawn
+func (o *Order) hasTarget() bool {
+	return o.Kind == OrderMove || o.Kind == OrderAttackMove || o.Kind == OrderPatrol || o.Kind == OrderAttack ||
+		o.Kind == OrderGather
+}
+
+// point where the order leads to, as it is drawn
+func (o *Order) point() engo.Point {
+	if o.Kind == OrderAttack && o.building != nil {
+		return o.building.rect.center().Engo()
+	}
+	if o.Kind == OrderAttack {
+		return o.Unit.SpaceComponent.Center()
+	}
+	return o.Target.Engo()
+}
+
+// command give the order of a command to a group of units. Queued orders are
+// carried out after the orders the units already have, otherwise they replace
+// them. Moves of a group take the formation of the command.
+func (us *UnitSpawner) command(units []*BasicUnit, enemy *BasicUnit, cmd Command) {
+	if len(units) == 0 {
+		return
+	}
+	kind, target := cmd.Kind, cmd.Target
+	if !cmd.Queue {
+		for _, unit := range units {
+			unit.orders = nil
+			unit.clearOrders()
+		}
+	}
+
+	switch kind {
+	case OrderMove, OrderAttackMove, OrderPatrol:
+		// Units with orders left continue from where those end
+		positions := make([]FixedPoint, len(units))
+		idle := true
+		for i, unit := range units {
+			positions[i] = unit.waypoint()
+			idle = idle && len(unit.orders) == 0
+		}
+		if idle {
+			// The group can start right away, and loose groups can share a
+			// flow field
+			for _, unit := range units {
+				unit.clearOrders()
+			}
+			for i, goal := range us.moveGroup(units, target, cmd.Formation) {
+				order := &Order{Kind: kind, Target: goal, pace: units[i].pace, from: positions[i], started: true}
+				units[i].orders = []*Order{order}
+				us.startCombat(units[i], order)
+			}
+			return
+		}
+		targets := us.groupTargets(units, positions, target, cmd.Formation)
+		pace := slowestSpeed(units)
+		for i, unit := range units {
+			unit.orders = append(unit.orders, &Order{Kind: kind, Target: targets[i], pace: pace, from: positions[i]})
+		}
+	case OrderAttack:
+		building := us.building(cmd.Building)
+		for _, unit := range units {
+			switch {
+			case enemy != nil && us.Hostile(unit, enemy):
+				unit.orders = append(unit.orders, &Order{Kind: kind, Unit: enemy})
+			case building != nil && us.Diplomacy.Hostile(unit.team, building.team):
+				unit.orders = append(unit.orders, &Order{Kind: kind, building: building})
+			}
+		}
+	case OrderGather:
+		// A node that ran out since the order was given is replaced by the
+		// nearest one around it. Units that can not gather walk up to it.
+		node := us.nodeAt(FixedToPathing(target))
+		if node == nil {
+			node = us.nearestNode(target, FixedFromInt(gatherSearch))
+		}
+		for _, unit := range units {
+			if node != nil && unit.CanGather() {
+				unit.orders = append(unit.orders, &Order{Kind: kind, Target: node.rect.center(), node: node})
+			} else {
+				unit.orders = append(unit.orders, &Order{Kind: OrderMove, Target: us.openSpot(target), from: unit.waypoint()})
+			}
+		}
+	default:
+		for _, unit := range units {
+			unit.orders = append(unit.orders, &Order{Kind: kind})
+		}
+	}
+	for _, unit := range units {
+		us.runOrders(unit)
+	}
+}
+
+// waypoint where the last order of the unit leaves it
+func (unit *BasicUnit) waypoint() FixedPoint {
+	for i := len(unit.orders) - 1; i >= 0; i-- {
+		if unit.orders[i].hasTarget() && unit.orders[i].Kind != OrderAttack {
+			return unit.orders[i].Target
+		}
+	}
+	return unit.pos
+}
+
+// groupTargets the target of every unit in a group move, units starting from
+// the given positions
+func (us *UnitSpawner) groupTargets(units []*BasicUnit, positions []FixedPoint, target FixedPoint, formation Formation) []FixedPoint {
+	if len(units) > 1 && formation != FormationLoose {
+		return us.formationTargets(units, positions, target, formation)
+	}
+	targets := make([]FixedPoint, len(units))
+	for i := range targets {
+		targets[i] = target
+	}
+	return targets
+}
+
+// runOrders start the current order of the unit, and move on to the next one
+// once it is done
+func (us *UnitSpawner) runOrders(unit *BasicUnit) {
+	for len(unit.orders) > 0 {
+		order := unit.orders[0]
+		if !order.started {
+			us.startOrder(unit, order)
+		}
+		if !us.orderDone(unit, order) {
+			return
+		}
+		if order.Kind == OrderPatrol {
+			// Turn around and walk the other way
+			order.Target, order.from = order.from, order.Target
+			order.started = false
+			us.startOrder(unit, order)
+			return
+		}
+		unit.orders[0] = nil
+		unit.orders = unit.orders[1:]
+	}
+}
+
+// startOrder hand an order to the pathfinder and to combat
+func (us *UnitSpawner) startOrder(unit *BasicUnit, order *Order) {
+	order.started = true
+	unit.clearOrders()
+	switch order.Kind {
+	case OrderMove, OrderAttackMove, OrderPatrol:
+		unit.pace = order.pace
+		us.paths.Request(unit, order.Target)
+		us.startCombat(unit, order)
+	case OrderAttack:
+		if order.building != nil {
+			if order.building.hp > 0 {
+				unit.siege = order.building
+			}
+		} else if order.Unit.Alive() {
+			unit.target = order.Unit
+			us.chase(unit)
+		}
+	case OrderHold:
+		unit.stop()
+		us.paths.Cancel(unit)
+		unit.hold = true
+	case OrderStop:
+		unit.stop()
+		us.paths.Cancel(unit)
+	case OrderGather:
+		unit.harvestLeft = 0
+		unit.gatherTries = 0
+	}
+}
+
+// startCombat set the combat state a move order needs
+func (us *UnitSpawner) startCombat(unit *BasicUnit, order *Order) {
+	if order.Kind == OrderAttackMove || order.Kind == OrderPatrol {
+		unit.attackMove = true
+		unit.attackGoal = order.Target
+	}
+}
+
+// orderDone check if the unit carried out its order
+func (us *UnitSpawner) orderDone(unit *BasicUnit, order *Order) bool {
+	switch order.Kind {
+	case OrderMove:
+		return !unit.moving() && !us.paths.Pending(unit)
+	case OrderAttackMove, OrderPatrol:
+		return !unit.attackMove
+	case OrderAttack:
+		return unit.target == nil && unit.siege == nil
+	case OrderHold:
+		// Held until the next order comes in
+		return len(unit.orders) > 1
+	case OrderGather:
+		return order.node == nil
+	}
+	return true
+}
+
+// gathering check if the unit is carrying out a gather order
+func (unit *BasicUnit) gathering() bool {
+	return len(unit.orders) > 0 && unit.orders[0].Kind == OrderGather && unit.orders[0].started
+}
+
+// harvest let the workers on a gather order work for a tick
+func (us *UnitSpawner) harvest() {
+	for _, unit := range us.AliveUnits {
+		if unit.gathering() && unit.orders[0].node != nil {
+			us.gather(unit, unit.orders[0])
+		}
+	}
+}
+
+// Orders that are not done yet, the current one first
+func (unit *BasicUnit) Orders() []*Order {
+	return unit.orders
+}
+
+// waypointWidth thickness of the lines between queued waypoints
+const waypointWidth = 2
+
+// waypointMark line or marker drawn for the orders of selected units
+type waypointMark struct {
+	ecs.BasicEntity
+	common.RenderComponent
+	common.SpaceComponent
+}
+
+// waypointColor colour of the line towards an order
+func waypointColor(kind OrderKind) color.Color {
+	switch kind {
+	case OrderAttackMove, OrderAttack:
+		return color.RGBA{220, 40, 40, 200}
+	case OrderPatrol:
+		return color.RGBA{40, 120, 230, 200}
+	case OrderGather:
+		return color.RGBA{230, 190, 40, 200}
+	}
+	return color.RGBA{40, 200, 40, 200}
+}
+
+// drawWaypoints draw lines along the orders of the selected units and to the
+// rally point of the selected building, with a marker at every waypoint
+func (us *UnitSpawner) drawWaypoints() {
+	if us.world == nil {
+		return
+	}
+	used := 0
+	mark := func(space common.SpaceComponent, c color.Color) {
+		if used == len(us.waypointMarks) {
+			m := &waypointMark{BasicEntity: ecs.NewBasic()}
+			m.RenderComponent = common.RenderComponent{Drawable: common.Rectangle{}}
+			m.RenderComponent.SetZIndex(1)
+			for _, system := range us.world.Systems() {
+				switch sys := system.(type) {
+				case *common.RenderSystem:
+					sys.Add(&m.BasicEntity, &m.RenderComponent, &m.SpaceComponent)
+				}
+			}
+			us.waypointMarks = append(us.waypointMarks, m)
+		}
+		m := us.waypointMarks[used]
+		m.SpaceComponent = space
+		m.RenderComponent.Color = c
+		m.RenderComponent.Hidden = false
+		used++
+	}
+
+	line := func(from, to engo.Point, c color.Color) {
+		dx, dy := to.X-from.X, to.Y-from.Y
+		// Rectangles rotate around their corner, which sits on the start
+		mark(common.SpaceComponent{
+			Position: engo.Point{X: from.X, Y: from.Y - waypointWidth/2},
+			Width:    float32(math.Hypot(float64(dx), float64(dy))),
+			Height:   waypointWidth,
+			Rotation: float32(math.Atan2(float64(dy), float64(dx)) * 180 / math.Pi),
+		}, c)
+		mark(common.SpaceComponent{
+			Position: engo.Point{X: to.X - 3*waypointWidth, Y: to.Y - 3*waypointWidth},
+			Width:    6 * waypointWidth,
+			Height:   6 * waypointWidth,
+		}, c)
+	}
+
+	for _, unit := range us.AliveUnits {
+		if !unit.selected {
+			continue
+		}
+		from := unit.SpaceComponent.Center()
+		for _, order := range unit.orders {
+			if !order.hasTarget() {
+				continue
+			}
+			to := order.point()
+			line(from, to, waypointColor(order.Kind))
+			from = to
+		}
+	}
+	for _, b := range us.buildings {
+		if b.selected && b.hasRally {
+			line(b.rect.center().Engo(), b.rally.Engo(), waypointColor(OrderRally))
+		}
+	}
+
+	for _, m := range us.waypointMarks[used:] {
+		m.RenderComponent.Hidden = true
+	}
+}
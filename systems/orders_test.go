@@ -0,0 +1,30 @@
+package systems
+
+import (
+	"testing"
+
+	"github.com/EngoEngine/engo"
+)
+
+func TestOrderQueuedAfterHold(t *testing.T) {
+	us := headlessSpawner(t)
+	defer us.Stop()
+	unit, err := us.SpawnUnitAtLocation(40, 40, "fish", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	units := []*BasicUnit{unit}
+
+	us.command(units, nil, Command{Kind: OrderHold})
+	if len(unit.orders) != 1 || !unit.hold {
+		t.Fatalf("the unit is not holding: %d orders, hold %v", len(unit.orders), unit.hold)
+	}
+
+	us.command(units, nil, Command{Kind: OrderMove, Target: ToFixedPoint(engo.Point{X: 200, Y: 40}), Queue: true})
+	if len(unit.orders) != 1 || unit.orders[0].Kind != OrderMove || !unit.orders[0].started {
+		t.Fatalf("the move queued after the hold did not start, %d orders left", len(unit.orders))
+	}
+	if unit.hold {
+		t.Error("the unit still holds while it moves")
+	}
+}
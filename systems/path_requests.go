@@ -0,0 +1,250 @@
+package systems
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Default size of the path worker pool and of its request queue
+const (
+	pathWorkers   = 4
+	pathQueueSize = 64
+)
+
+// PathRequest a search queued for one or more units. A request either
+// searches a single path, or builds a flow field shared by all its units.
+type PathRequest struct {
+	units     []*BasicUnit
+	waiting   int // units that have not been superseded yet
+	source    []Point
+	target    []Point
+	flow      bool
+	cancelled int32
+
+	path  *PathPoint
+	field *FlowField
+	done  chan struct{} // closed once a worker is done with the request
+}
+
+// Cancel stop the request from being delivered. A search that is already
+// running finishes in the background but its result is thrown away.
+func (r *PathRequest) Cancel() {
+	atomic.StoreInt32(&r.cancelled, 1)
+}
+
+// Cancelled check if the request was cancelled or superseded
+func (r *PathRequest) Cancelled() bool {
+	return atomic.LoadInt32(&r.cancelled) == 1
+}
+
+// PathService runs path searches on a bounded pool of goroutines so that
+// ordering many units does not block the game loop. Requests made during a
+// tick are handed to the workers by Flush at its end, and their results to
+// the units by Deliver at the start of the next tick, in the order they were
+// requested. Every method except the workers themselves must be called from
+// the game loop.
+type PathService struct {
+	ast AStar
+	cfg AStarConfig
+
+	queue     chan *PathRequest
+	submitted []*PathRequest // requests of the current tick
+	inflight  []*PathRequest // flushed requests, in the order they were made
+	backlog   []*PathRequest // flushed requests that did not fit in the queue yet
+	pending   map[*BasicUnit]*PathRequest
+
+	stop sync.Once
+	wg   sync.WaitGroup
+}
+
+// NewPathService start a path service with the given number of workers
+func NewPathService(ast AStar, cfg AStarConfig, workers int) *PathService {
+	ps := &PathService{
+		ast:     ast,
+		cfg:     cfg,
+		queue:   make(chan *PathRequest, pathQueueSize),
+		pending: make(map[*BasicUnit]*PathRequest),
+	}
+	for i := 0; i < workers; i++ {
+		ps.wg.Add(1)
+		go ps.work()
+	}
+	return ps
+}
+
+// work search paths until the queue is closed
+func (ps *PathService) work() {
+	defer ps.wg.Done()
+	for req := range ps.queue {
+		if !req.Cancelled() {
+			if req.flow {
+				req.field = ps.ast.(FlowFielder).FlowField(req.target[0])
+			} else {
+				req.path = ps.ast.FindPath(ps.cfg, req.source, req.target)
+			}
+		}
+		close(req.done)
+	}
+}
+
+// Request queue a search moving unit to target. Any request still pending for
+// the unit is superseded and will not be delivered.
+func (ps *PathService) Request(unit *BasicUnit, target FixedPoint) *PathRequest {
+	req := &PathRequest{
+		units:  []*BasicUnit{unit},
+		source: []Point{FixedToPathing(unit.pos)},
+		target: []Point{FixedToPathing(target)},
+	}
+	ps.submit(req)
+	return req
+}
+
+// RequestFlow queue building the flow field towards target, shared by all the
+// given units once it is done. The grid must implement FlowFielder.
+func (ps *PathService) RequestFlow(units []*BasicUnit, target Point) *PathRequest {
+	req := &PathRequest{
+		units:  units,
+		target: []Point{target},
+		flow:   true,
+	}
+	ps.submit(req)
+	return req
+}
+
+// submit supersede whatever the units were waiting for and keep the request
+// until the tick is flushed
+func (ps *PathService) submit(req *PathRequest) {
+	for _, unit := range req.units {
+		ps.Cancel(unit)
+		ps.pending[unit] = req
+	}
+	req.waiting = len(req.units)
+	req.done = make(chan struct{})
+	ps.submitted = append(ps.submitted, req)
+}
+
+// Cancel drop the pending request of a unit, if any. The request itself is
+// only cancelled once none of its units are waiting for it anymore.
+func (ps *PathService) Cancel(unit *BasicUnit) {
+	req, ok := ps.pending[unit]
+	if !ok {
+		return
+	}
+	delete(ps.pending, unit)
+	req.waiting--
+	if req.waiting == 0 {
+		req.Cancel()
+	}
+}
+
+// Pending check if a unit is still waiting for a path
+func (ps *PathService) Pending(unit *BasicUnit) bool {
+	_, ok := ps.pending[unit]
+	return ok
+}
+
+// Flush hand the requests of the tick that were not cancelled to the workers
+func (ps *PathService) Flush() {
+	for _, req := range ps.submitted {
+		if req.Cancelled() {
+			continue
+		}
+		ps.inflight = append(ps.inflight, req)
+		ps.enqueue(req)
+	}
+	ps.submitted = nil
+}
+
+// enqueue hand the request to the workers without blocking, keeping it in
+// the backlog when the queue is full
+func (ps *PathService) enqueue(req *PathRequest) {
+	if len(ps.backlog) == 0 {
+		select {
+		case ps.queue <- req:
+			return
+		default:
+		}
+	}
+	ps.backlog = append(ps.backlog, req)
+}
+
+// Ready check without blocking if Deliver can run without waiting: every
+// flushed request that was not cancelled has been searched. Requests that did
+// not fit in the queue are handed to the workers as it empties.
+func (ps *PathService) Ready() bool {
+	for len(ps.backlog) > 0 {
+		select {
+		case ps.queue <- ps.backlog[0]:
+			ps.backlog = ps.backlog[1:]
+		default:
+			return false
+		}
+	}
+	for _, req := range ps.inflight {
+		if req.Cancelled() {
+			continue
+		}
+		select {
+		case <-req.done:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Deliver give the paths of the flushed requests to the units that are still
+// waiting for them. Paths are handed out at the start of the tick after the
+// one they were requested in, whatever the timing, so that the simulation
+// stays deterministic: searches that are still running are waited for. The
+// game loop only ticks once the service is Ready, so this only waits in
+// headless runs and when seeking a replay.
+func (ps *PathService) Deliver() {
+	for _, req := range ps.backlog {
+		ps.queue <- req
+	}
+	ps.backlog = nil
+
+	// Paths are searched on the grid as it was when they were flushed, at
+	// the end of the last tick
+	var version uint64
+	if grid, ok := ps.ast.(Grid); ok {
+		version = grid.Version()
+	}
+	for _, req := range ps.inflight {
+		if req.Cancelled() {
+			// The result is thrown away, there is nothing to wait for
+			continue
+		}
+		<-req.done
+		for _, unit := range req.units {
+			if ps.pending[unit] != req {
+				continue
+			}
+			delete(ps.pending, unit)
+			unit.path = req.path
+			unit.flow = req.field
+			unit.goal = req.target[0]
+			unit.pathVersion = version
+			unit.blockedFor = 0
+			unit.stuck = NotStuck
+			if !req.flow && req.path == nil {
+				unit.stuck = StuckNoPath
+			}
+		}
+	}
+	ps.inflight = nil
+}
+
+// Stop cancel everything that is pending and wait for the workers to exit
+func (ps *PathService) Stop() {
+	ps.stop.Do(func() {
+		for unit := range ps.pending {
+			ps.Cancel(unit)
+		}
+		ps.submitted = nil
+		ps.backlog = nil
+		close(ps.queue)
+		ps.wg.Wait()
+	})
+}
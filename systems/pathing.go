@@ -47,6 +47,25 @@ type AStar interface {
 	FindPath(config AStarConfig, source, target []Point) *PathPoint
 }
 
+// Grid is implemented by the AStar grids so their tiles can be read back
+type Grid interface {
+	// Size of the grid in tiles
+	Size() (rows, cols int)
+
+	// Weight of a tile as given to FillTile, 0 if it was never filled
+	// and -1 for tiles outside the grid
+	Weight(p Point) int
+
+	// Version counts the changes to the tiles, it goes up with every
+	// FillTile or ClearTile that changes a weight
+	Version() uint64
+
+	// Changed the tiles whose weight changed after the given version, in
+	// the order they changed. ok is false when the grid no longer remembers
+	// changes that old.
+	Changed(since uint64) (tiles []Point, ok bool)
+}
+
 // AStarConfig The user built configuration that determines how weights are calculated and
 // also determines the stopping condition
 type AStarConfig interface {
@@ -65,22 +84,90 @@ type AStarConfig interface {
 	PostProcess(p *PathPoint, rows, cols int, filledTiles map[Point]int) *PathPoint
 }
 
+// Neighbourhood determines which tiles are adjacent to each other during a search
+type Neighbourhood int
+
+const (
+	// FourWay only the orthogonal neighbours, every step costs 1
+	FourWay Neighbourhood = iota
+	// EightWay orthogonal and diagonal neighbours, steps cost StraightCost and DiagonalCost
+	EightWay
+)
+
+// CornerRule determines when a diagonal step is allowed to pass the two
+// orthogonal tiles it cuts past. Only impassable (-1) tiles count as filled here.
+type CornerRule int
+
+const (
+	// NoCornerCutting diagonal steps need both orthogonal tiles to be free
+	NoCornerCutting CornerRule = iota
+	// CutOneCorner diagonal steps may cut past one filled tile, but not squeeze between two
+	CutOneCorner
+	// SqueezeBetween diagonal steps are always allowed, even between two filled tiles
+	SqueezeBetween
+)
+
+// Costs of a single step on an EightWay grid. A diagonal costs roughly sqrt(2)
+// times a straight step. Fill weights are scaled by StraightCost on these grids
+// so that a tile weight still means "this many extra steps".
+const (
+	StraightCost = 10
+	DiagonalCost = 14
+)
+
+// changeLogSize changes to the tiles a grid remembers for Changed
+const changeLogSize = 4096
+
 type gridStruct struct {
 	// A list of filled tiles and their weight
 	tileLock    sync.Mutex
 	filledTiles map[Point]int
+	// Bumped whenever a tile changes
+	version uint64
+	// Tile changed by every version after changesFrom, up to version
+	changes     []Point
+	changesFrom uint64
+
+	// Flow fields by target, only valid for the version they were built on
+	flowLock   sync.Mutex
+	flowFields map[Point]*FlowField
 
 	rows int
 	cols int
+
+	neighbourhood Neighbourhood
+	corners       CornerRule
+	straightCost  int
+	diagonalCost  int
 }
 
-// NewAStar new A*
+// NewAStar new four-way A*
 func NewAStar(rows, cols int) AStar {
 	return &gridStruct{
 		rows: rows,
 		cols: cols,
 
 		filledTiles: make(map[Point]int),
+
+		neighbourhood: FourWay,
+		straightCost:  1,
+	}
+}
+
+// NewAStarEightWay new A* that also steps diagonally, following the given rule
+// for cutting corners. Pair it with a config using OctileHeuristic or
+// ChebyshevHeuristic, e.g. NewPointToPointWithHeuristic(OctileHeuristic).
+func NewAStarEightWay(rows, cols int, corners CornerRule) AStar {
+	return &gridStruct{
+		rows: rows,
+		cols: cols,
+
+		filledTiles: make(map[Point]int),
+
+		neighbourhood: EightWay,
+		corners:       corners,
+		straightCost:  StraightCost,
+		diagonalCost:  DiagonalCost,
 	}
 }
 
@@ -88,18 +175,87 @@ func (a *gridStruct) FillTile(p Point, weight int) {
 	a.tileLock.Lock()
 	defer a.tileLock.Unlock()
 
+	if old, ok := a.filledTiles[p]; ok && old == weight {
+		return
+	}
 	a.filledTiles[p] = weight
+	a.logChange(p)
 }
 
 func (a *gridStruct) ClearTile(p Point) {
 	a.tileLock.Lock()
 	defer a.tileLock.Unlock()
 
+	if _, ok := a.filledTiles[p]; !ok {
+		return
+	}
 	delete(a.filledTiles, p)
+	a.logChange(p)
+}
+
+// logChange bump the version for a changed tile and remember the tile, the
+// oldest half of the changes is forgotten once the log is full. The tile lock
+// must be held.
+func (a *gridStruct) logChange(p Point) {
+	a.version++
+	a.changes = append(a.changes, p)
+	if len(a.changes) > changeLogSize {
+		drop := len(a.changes) - changeLogSize/2
+		a.changes = append([]Point(nil), a.changes[drop:]...)
+		a.changesFrom += uint64(drop)
+	}
+}
+
+// tileRect a rectangle of tiles, from (x0, y0) up to but not including (x1, y1)
+type tileRect struct {
+	x0, y0, x1, y1 int
+}
+
+func (r tileRect) contains(p Point) bool {
+	return p.X >= r.x0 && p.X < r.x1 && p.Y >= r.y0 && p.Y < r.y1
+}
+
+func (a *gridStruct) Size() (rows, cols int) {
+	return a.rows, a.cols
+}
+
+func (a *gridStruct) Weight(p Point) int {
+	if p.X < 0 || p.X >= a.rows || p.Y < 0 || p.Y >= a.cols {
+		return -1
+	}
+	a.tileLock.Lock()
+	defer a.tileLock.Unlock()
+	return a.filledTiles[p]
+}
+
+func (a *gridStruct) Version() uint64 {
+	a.tileLock.Lock()
+	defer a.tileLock.Unlock()
+	return a.version
+}
+
+func (a *gridStruct) Changed(since uint64) ([]Point, bool) {
+	a.tileLock.Lock()
+	defer a.tileLock.Unlock()
+	if since < a.changesFrom || since > a.version {
+		return nil, false
+	}
+	return append([]Point(nil), a.changes[since-a.changesFrom:]...), true
 }
 
 func (a *gridStruct) FindPath(config AStarConfig, source, target []Point) *PathPoint {
-	var openList = make(map[Point]*PathPoint)
+	current := a.search(config, source, target, tileRect{0, 0, a.rows, a.cols})
+
+	a.tileLock.Lock()
+	current = config.PostProcess(current, a.rows, a.cols, a.filledTiles)
+	a.tileLock.Unlock()
+
+	return current
+}
+
+// search run A* without post processing, never leaving the given bounds
+func (a *gridStruct) search(config AStarConfig, source, target []Point, bounds tileRect) *PathPoint {
+	openList := newOpenList()
 	var closeList = make(map[Point]*PathPoint)
 
 	sourceMap := make(map[Point]bool)
@@ -114,12 +270,16 @@ func (a *gridStruct) FindPath(config AStarConfig, source, target []Point) *PathP
 			Point:        p,
 			Parent:       nil,
 			DistTraveled: 0,
-			FillWeight:   fillWeight,
+			FillWeight:   fillWeight * a.straightCost,
 		}
 
 		allowed := config.SetWeight(pathPoint, fillWeight, source, sourceMap)
 		if allowed {
-			openList[p] = pathPoint
+			if existingPoint, ok := openList.get(p); !ok {
+				openList.push(pathPoint)
+			} else if pathPoint.Weight < existingPoint.Weight {
+				openList.decrease(pathPoint)
+			}
 		}
 	}
 
@@ -127,7 +287,7 @@ func (a *gridStruct) FindPath(config AStarConfig, source, target []Point) *PathP
 
 	var current *PathPoint
 	for {
-		current = a.getMinWeight(openList)
+		current = openList.peekMin()
 
 		a.tileLock.Lock()
 		if current == nil || config.IsEnd(current.Point, source, sourceMap) {
@@ -136,14 +296,14 @@ func (a *gridStruct) FindPath(config AStarConfig, source, target []Point) *PathP
 		}
 		a.tileLock.Unlock()
 
-		delete(openList, current.Point)
+		openList.popMin()
 		closeList[current.Point] = current
 
 		surrounding := a.getSurrounding(current.Point)
 
 		for _, p := range surrounding {
 			_, ok := closeList[p]
-			if ok {
+			if ok || !bounds.contains(p) {
 				continue
 			}
 
@@ -154,8 +314,8 @@ func (a *gridStruct) FindPath(config AStarConfig, source, target []Point) *PathP
 			pathPoint := &PathPoint{
 				Point:        p,
 				Parent:       current,
-				FillWeight:   current.FillWeight + fillWeight,
-				DistTraveled: current.DistTraveled + 1,
+				FillWeight:   current.FillWeight + fillWeight*a.straightCost,
+				DistTraveled: current.DistTraveled + a.stepCost(current.Point, p),
 			}
 
 			a.tileLock.Lock()
@@ -166,37 +326,19 @@ func (a *gridStruct) FindPath(config AStarConfig, source, target []Point) *PathP
 				continue
 			}
 
-			existingPoint, ok := openList[p]
+			existingPoint, ok := openList.get(p)
 			if !ok {
-				openList[p] = pathPoint
-			} else {
-				if pathPoint.Weight < existingPoint.Weight {
-					existingPoint.Parent = pathPoint.Parent
-				}
+				openList.push(pathPoint)
+			} else if pathPoint.Weight < existingPoint.Weight {
+				// Found a cheaper way to reach an open point
+				openList.decrease(pathPoint)
 			}
 		}
 	}
 
-	a.tileLock.Lock()
-	current = config.PostProcess(current, a.rows, a.cols, a.filledTiles)
-	a.tileLock.Unlock()
-
 	return current
 }
 
-func (a *gridStruct) getMinWeight(openList map[Point]*PathPoint) *PathPoint {
-	var min *PathPoint = nil
-	var minWeight int = 0
-
-	for _, p := range openList {
-		if min == nil || p.Weight < minWeight {
-			min = p
-			minWeight = p.Weight
-		}
-	}
-	return min
-}
-
 func (a *gridStruct) getSurrounding(p Point) []Point {
 	var surrounding []Point
 
@@ -216,12 +358,67 @@ func (a *gridStruct) getSurrounding(p Point) []Point {
 		surrounding = append(surrounding, Point{row, col + 1})
 	}
 
+	if a.neighbourhood != EightWay {
+		return surrounding
+	}
+
+	a.tileLock.Lock()
+	defer a.tileLock.Unlock()
+	for _, dx := range []int{-1, 1} {
+		for _, dy := range []int{-1, 1} {
+			r, c := row+dx, col+dy
+			if r < 0 || r >= a.rows || c < 0 || c >= a.cols {
+				continue
+			}
+			if a.diagonalAllowed(p, Point{r, c}) {
+				surrounding = append(surrounding, Point{r, c})
+			}
+		}
+	}
+
 	return surrounding
 }
 
+// diagonalAllowed check the corner rule for a diagonal step, tileLock must be held
+func (a *gridStruct) diagonalAllowed(from, to Point) bool {
+	blocked := 0
+	if a.filledTiles[Point{to.X, from.Y}] == -1 {
+		blocked++
+	}
+	if a.filledTiles[Point{from.X, to.Y}] == -1 {
+		blocked++
+	}
+
+	switch a.corners {
+	case NoCornerCutting:
+		return blocked == 0
+	case CutOneCorner:
+		return blocked < 2
+	default:
+		return true
+	}
+}
+
+// heuristic the exact distance on an open grid of this neighbourhood
+func (a *gridStruct) heuristic() Heuristic {
+	if a.neighbourhood == EightWay {
+		return OctileHeuristic
+	}
+	return ManhattanHeuristic
+}
+
+// stepCost cost of moving between two neighbouring points
+func (a *gridStruct) stepCost(from, to Point) int {
+	if from.X != to.X && from.Y != to.Y {
+		return a.diagonalCost
+	}
+	return a.straightCost
+}
+
 // PathPoint A point along a path.
 // FillWeight is the sum of all the fill weights so far and
-// DistTraveled is the total distance traveled so far
+// DistTraveled is the total distance traveled so far. Both are in step cost
+// units, which is 1 per step on a FourWay grid.
 //
 // WeightData is an interface that can be set to anything that Config wants
 // it will never be touched by the rest of the code but if you wish to
@@ -235,31 +432,89 @@ type PathPoint struct {
 	DistTraveled int
 
 	WeightData interface{}
+
+	// Position in the open list while the point is being searched
+	heapIndex int
 }
 
-// Dist Manhattan distance NOT euclidean distance because in our routing we cant go diagonally between the points.
+// Dist Manhattan distance NOT euclidean distance because in our four-way routing we cant go diagonally between the points.
 func (p Point) Dist(other Point) int {
 	return int(math.Abs(float64(p.X-other.X)) + math.Abs(float64(p.Y-other.Y)))
 }
 
+// Chebyshev number of steps between two points when diagonal steps are allowed
+func (p Point) Chebyshev(other Point) int {
+	dx := int(math.Abs(float64(p.X - other.X)))
+	dy := int(math.Abs(float64(p.Y - other.Y)))
+	if dx > dy {
+		return dx
+	}
+	return dy
+}
+
+// Heuristic estimate of the cost of travelling between two points, used by the configs
+type Heuristic func(a, b Point) int
+
+// ManhattanHeuristic exact cost between two points on an open FourWay grid
+func ManhattanHeuristic(a, b Point) int {
+	return a.Dist(b)
+}
+
+// OctileHeuristic exact cost between two points on an open EightWay grid
+func OctileHeuristic(a, b Point) int {
+	diagonal := int(math.Min(math.Abs(float64(a.X-b.X)), math.Abs(float64(a.Y-b.Y))))
+	straight := a.Chebyshev(b) - diagonal
+	return straight*StraightCost + diagonal*DiagonalCost
+}
+
+// ChebyshevHeuristic cheaper, less informed estimate for EightWay grids that
+// prices diagonal steps like straight ones
+func ChebyshevHeuristic(a, b Point) int {
+	return a.Chebyshev(b) * StraightCost
+}
+
 //######################################################################
 //######################################################################
 
 type pointToPoint struct {
+	heuristic Heuristic
+}
+
+type pointToPointForward struct {
+	pointToPoint
 	VoidPostProcess
 }
 
+type pointToPointSmooth struct {
+	pointToPoint
+	SmoothPostProcess
+}
+
 // Basic point to point routing, only a single source
 // is supported and it will panic if given multiple sources
 //
 // Weights are calulated by summing the tiles fill_weight, the total distance traveled
 // and the current distance from the target
 func NewPointToPoint() AStarConfig {
-	p2p := &pointToPoint{}
+	p2p := &pointToPointForward{pointToPoint: pointToPoint{heuristic: ManhattanHeuristic}}
 
 	return p2p
 }
 
+// NewPointToPointWithHeuristic point to point routing that estimates the distance
+// to the target with the given heuristic instead of the Manhattan distance.
+// Use OctileHeuristic or ChebyshevHeuristic on grids from NewAStarEightWay.
+func NewPointToPointWithHeuristic(h Heuristic) AStarConfig {
+	return &pointToPointForward{pointToPoint: pointToPoint{heuristic: h}}
+}
+
+// NewPointToPointSmooth point to point routing with the given heuristic, where
+// the final path is passed through SmoothPostProcess so it only keeps the
+// waypoints where the direction has to change.
+func NewPointToPointSmooth(h Heuristic) AStarConfig {
+	return &pointToPointSmooth{pointToPoint: pointToPoint{heuristic: h}}
+}
+
 func (p2p *pointToPoint) SetWeight(p *PathPoint, fill_weight int, end []Point, end_map map[Point]bool) bool {
 	if len(end) != 1 {
 		panic("Invalid end specified")
@@ -269,7 +524,7 @@ func (p2p *pointToPoint) SetWeight(p *PathPoint, fill_weight int, end []Point, e
 		return false
 	}
 
-	p.Weight = p.FillWeight + p.DistTraveled + p.Point.Dist(end[0])
+	p.Weight = p.FillWeight + p.DistTraveled + p2p.heuristic(p.Point, end[0])
 
 	return true
 }
@@ -412,3 +667,83 @@ func (v *ReversePostProcess) PostProcess(p *PathPoint, rows, cols int, filledTil
 
 	return path_prev
 }
+
+// A post processing struct that removes redundant waypoints from the path.
+// A waypoint is dropped when the tile before it can see the tile after it,
+// so the path becomes a chain of straight segments over open ground.
+// Any filled tile blocks line of sight, including weighted but passable ones,
+// so smoothing never makes the path cross terrain it was routed around.
+type SmoothPostProcess struct {
+}
+
+func (v *SmoothPostProcess) PostProcess(p *PathPoint, rows, cols int, filledTiles map[Point]int) *PathPoint {
+	if p == nil {
+		return nil
+	}
+
+	anchor := p
+	prev := p.Parent
+	if prev == nil {
+		return p
+	}
+	for next := prev.Parent; next != nil; next = next.Parent {
+		if !LineOfSight(anchor.Point, next.Point, filledTiles) {
+			// prev is the furthest point visible from anchor, keep it
+			anchor.Parent = prev
+			anchor = prev
+		}
+		prev = next
+	}
+	anchor.Parent = prev
+
+	return p
+}
+
+// LineOfSight check if the straight line between the centers of two tiles only
+// crosses free tiles. Every tile the line touches is checked; when the line
+// passes exactly through a corner both tiles next to that corner must be free.
+func LineOfSight(a, b Point, filledTiles map[Point]int) bool {
+	return !lineTouches(a, b, func(p Point) bool { return filledTiles[p] != 0 })
+}
+
+// lineTouches check if the straight line between the centers of two tiles
+// touches a tile that hit reports. When the line passes exactly through a
+// corner both tiles next to that corner count as touched.
+func lineTouches(a, b Point, hit func(Point) bool) bool {
+	dx := int(math.Abs(float64(b.X - a.X)))
+	dy := int(math.Abs(float64(b.Y - a.Y)))
+	sx, sy := 1, 1
+	if b.X < a.X {
+		sx = -1
+	}
+	if b.Y < a.Y {
+		sy = -1
+	}
+
+	x, y := a.X, a.Y
+	err := dx - dy
+	dx *= 2
+	dy *= 2
+	for n := 1 + dx/2 + dy/2; n > 0; n-- {
+		if hit(Point{x, y}) {
+			return true
+		}
+		if err > 0 {
+			x += sx
+			err -= dy
+		} else if err < 0 {
+			y += sy
+			err += dx
+		} else {
+			// Exactly through a corner, the diagonal counts as two moves
+			if hit(Point{x + sx, y}) || hit(Point{x, y + sy}) {
+				return true
+			}
+			x += sx
+			y += sy
+			err += dx - dy
+			n--
+		}
+	}
+	return false
+}
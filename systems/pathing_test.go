@@ -0,0 +1,200 @@
+package systems
+
+import "testing"
+
+// Size of the benchmark grids in tiles
+const benchGridSize = 120
+
+// benchGrid a grid to search with its source and target
+type benchGrid struct {
+	ast            AStar
+	source, target Point
+}
+
+// openGrid a grid without filled tiles, searched corner to corner
+func openGrid() benchGrid {
+	return benchGrid{
+		ast:    NewAStar(benchGridSize, benchGridSize),
+		source: Point{0, 0},
+		target: Point{benchGridSize - 1, benchGridSize - 1},
+	}
+}
+
+// mazyGrid a grid of walls every other row with a gap at alternating ends,
+// so the path snakes through the whole grid
+func mazyGrid() benchGrid {
+	ast := NewAStar(benchGridSize, benchGridSize)
+	for x := 1; x < benchGridSize; x += 2 {
+		gap := 0
+		if (x/2)%2 == 0 {
+			gap = benchGridSize - 1
+		}
+		for y := 0; y < benchGridSize; y++ {
+			if y != gap {
+				ast.FillTile(Point{x, y}, -1)
+			}
+		}
+	}
+	return benchGrid{ast: ast, source: Point{0, 0}, target: Point{benchGridSize - 1, 0}}
+}
+
+// blockedGrid a grid where the source is walled in. Searches run from the
+// target, so they visit every tile outside the wall before giving up.
+func blockedGrid() benchGrid {
+	ast := NewAStar(benchGridSize, benchGridSize)
+	source := Point{benchGridSize / 2, benchGridSize / 2}
+	for x := source.X - 1; x <= source.X+1; x++ {
+		for y := source.Y - 1; y <= source.Y+1; y++ {
+			if (Point{x, y}) != source {
+				ast.FillTile(Point{x, y}, -1)
+			}
+		}
+	}
+	return benchGrid{ast: ast, source: source, target: Point{0, 0}}
+}
+
+// linearFindPath the search as it was before the open list became a heap:
+// the open points are kept in a map and the cheapest one is found by scanning
+// all of them
+func linearFindPath(a *gridStruct, config AStarConfig, source, target []Point) *PathPoint {
+	openList := make(map[Point]*PathPoint)
+	closeList := make(map[Point]*PathPoint)
+
+	sourceMap := make(map[Point]bool)
+	for _, p := range source {
+		sourceMap[p] = true
+	}
+
+	for _, p := range target {
+		fillWeight := a.filledTiles[p]
+		pathPoint := &PathPoint{Point: p, FillWeight: fillWeight * a.straightCost}
+		if config.SetWeight(pathPoint, fillWeight, source, sourceMap) {
+			openList[p] = pathPoint
+		}
+	}
+
+	var current *PathPoint
+	for {
+		current = getMinWeight(openList)
+		if current == nil || config.IsEnd(current.Point, source, sourceMap) {
+			break
+		}
+		delete(openList, current.Point)
+		closeList[current.Point] = current
+
+		for _, p := range a.getSurrounding(current.Point) {
+			if _, ok := closeList[p]; ok {
+				continue
+			}
+			fillWeight := a.filledTiles[p]
+			pathPoint := &PathPoint{
+				Point:        p,
+				Parent:       current,
+				FillWeight:   current.FillWeight + fillWeight*a.straightCost,
+				DistTraveled: current.DistTraveled + a.stepCost(current.Point, p),
+			}
+			if !config.SetWeight(pathPoint, fillWeight, source, sourceMap) {
+				continue
+			}
+			existingPoint, ok := openList[p]
+			if !ok {
+				openList[p] = pathPoint
+			} else if pathPoint.Weight < existingPoint.Weight {
+				existingPoint.Parent = pathPoint.Parent
+			}
+		}
+	}
+	return current
+}
+
+// getMinWeight the open point with the lowest weight, or nil
+func getMinWeight(openList map[Point]*PathPoint) *PathPoint {
+	var min *PathPoint
+	for _, p := range openList {
+		if min == nil || p.Weight < min.Weight {
+			min = p
+		}
+	}
+	return min
+}
+
+// weightedGrid an open grid with bands of slow tiles, some of them slower
+// than walking around, searched corner to corner
+func weightedGrid() benchGrid {
+	ast := NewAStar(benchGridSize, benchGridSize)
+	for x := 10; x < benchGridSize; x += 20 {
+		for y := 0; y < benchGridSize-5; y++ {
+			ast.FillTile(Point{x, y}, 1+(y/10)%4*3)
+		}
+	}
+	return benchGrid{ast: ast, source: Point{0, 0}, target: Point{benchGridSize - 1, 0}}
+}
+
+// pathCost the cost of walking a path on a grid: its steps and the fill
+// weight of every tile on it, as the search adds them up
+func pathCost(a *gridStruct, p *PathPoint) int {
+	cost := 0
+	for ; p != nil; p = p.Parent {
+		cost += a.filledTiles[p.Point] * a.straightCost
+		if p.Parent != nil {
+			cost += a.stepCost(p.Point, p.Parent.Point)
+		}
+	}
+	return cost
+}
+
+func TestFindPathMatchesLinear(t *testing.T) {
+	grids := map[string]func() benchGrid{"open": openGrid, "mazy": mazyGrid, "blocked": blockedGrid, "weighted": weightedGrid}
+	for name, newGrid := range grids {
+		g := newGrid()
+		grid := g.ast.(*gridStruct)
+		source, target := []Point{g.source}, []Point{g.target}
+		heap := g.ast.FindPath(NewPointToPoint(), source, target)
+		linear := linearFindPath(grid, NewPointToPoint(), source, target)
+		if (heap == nil) != (linear == nil) {
+			t.Errorf("%s grid: heap found a path %v, linear %v", name, heap != nil, linear != nil)
+			continue
+		}
+		if heap == nil {
+			continue
+		}
+		if cost := heap.DistTraveled + heap.FillWeight; cost != pathCost(grid, heap) {
+			t.Errorf("%s grid: heap path reports cost %d, walking it costs %d", name, cost, pathCost(grid, heap))
+		}
+		if pathCost(grid, heap) != pathCost(grid, linear) {
+			t.Errorf("%s grid: heap path costs %d, linear path %d", name, pathCost(grid, heap), pathCost(grid, linear))
+		}
+	}
+}
+
+// benchmarkFindPath search a grid with the heap and with the linear open list
+func benchmarkFindPath(b *testing.B, newGrid func() benchGrid) {
+	g := newGrid()
+	source, target := []Point{g.source}, []Point{g.target}
+	b.Run("heap", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			g.ast.FindPath(NewPointToPoint(), source, target)
+		}
+	})
+	b.Run("linear", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			linearFindPath(g.ast.(*gridStruct), NewPointToPoint(), source, target)
+		}
+	})
+}
+
+func BenchmarkFindPathOpen(b *testing.B) {
+	benchmarkFindPath(b, openGrid)
+}
+
+func BenchmarkFindPathMazy(b *testing.B) {
+	benchmarkFindPath(b, mazyGrid)
+}
+
+func BenchmarkFindPathBlocked(b *testing.B) {
+	benchmarkFindPath(b, blockedGrid)
+}
+
+func BenchmarkFindPathWeighted(b *testing.B) {
+	benchmarkFindPath(b, weightedGrid)
+}
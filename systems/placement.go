@@ -0,0 +1,163 @@
+package systems
+
+import (
+	"fmt"
+	"image/color"
+	"log"
+	"math"
+
+	"github.com/EngoEngine/ecs"
+	"github.com/EngoEngine/engo"
+	"github.com/EngoEngine/engo/common"
+)
+
+// Names of the building buttons
+const (
+	buildButton  = "Build"  // starts placing a building with a worker selected, again for the next type
+	cancelButton = "Cancel" // stops placing
+)
+
+// trainKeys keys that train the first, second and third unit type of the
+// selected building
+var trainKeys = []engo.Key{engo.KeyT, engo.KeyY, engo.KeyU}
+
+// Colours of the preview of the building being placed
+var (
+	ghostValid   = color.RGBA{40, 200, 40, 120}
+	ghostInvalid = color.RGBA{220, 40, 40, 120}
+)
+
+// trainButton name of the button that trains the i-th unit type, from 0
+func trainButton(i int) string {
+	return fmt.Sprintf("Train%d", i+1)
+}
+
+// registerBuildingButtons register the keys for placing buildings and
+// training units
+func registerBuildingButtons() {
+	engo.Input.RegisterButton(buildButton, engo.KeyB)
+	engo.Input.RegisterButton(cancelButton, engo.KeyEscape)
+	for i, key := range trainKeys {
+		engo.Input.RegisterButton(trainButton(i), key)
+	}
+}
+
+// newGhost create the hidden preview of the building being placed
+func (s *MouseFollower) newGhost() {
+	s.ghost = Box{BasicEntity: ecs.NewBasic()}
+	s.ghost.RenderComponent = common.RenderComponent{Drawable: common.Rectangle{}, Hidden: true}
+	// Over the buildings and the fog
+	s.ghost.RenderComponent.SetZIndex(3)
+}
+
+// selectBuilding replace the selected building, nil for none
+func (s *MouseFollower) selectBuilding(b *Building) {
+	if s.building != nil {
+		s.building.Deselect()
+	}
+	if b != nil {
+		b.Select()
+	}
+	s.building = b
+}
+
+// clickedBuilding the building of the player under the mouse, or nil
+func (s *MouseFollower) clickedBuilding(sys *UnitSpawner, mouse engo.Point) *Building {
+	b := sys.buildingAt(EngoToPathing(mouse))
+	if b == nil || b.team != sys.Player {
+		return nil
+	}
+	return b
+}
+
+// updateBuilding train units in the selected building with the train keys
+func (s *MouseFollower) updateBuilding(sys *UnitSpawner) {
+	if s.building == nil {
+		return
+	}
+	for i := range trainKeys {
+		if i < len(s.building.kind.Produces) && engo.Input.Button(trainButton(i)).JustPressed() {
+			sys.Issue(Command{Kind: OrderTrain, Building: s.building.id, Type: s.building.kind.Produces[i], Team: sys.Player})
+		}
+	}
+}
+
+// startPlacing move on to placing the next building type by name, or stop
+// after the last one
+func (s *MouseFollower) startPlacing(sys *UnitSpawner) {
+	names := sys.BuildingTypes.Names()
+	next := 0
+	if s.placing != nil {
+		for i, name := range names {
+			if name == s.placing.Name {
+				next = i + 1
+			}
+		}
+	}
+	if next == len(names) {
+		s.stopPlacing()
+		return
+	}
+	t, err := sys.BuildingTypes.Lookup(names[next])
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	s.placing = t
+	s.arm(OrderMove)
+	log.Println("Placing:", t.Name)
+}
+
+// stopPlacing leave the placement mode
+func (s *MouseFollower) stopPlacing() {
+	s.placing = nil
+	s.ghost.RenderComponent.Hidden = true
+}
+
+// updatePlacement show where the building being placed would go and place it
+// on a left click, Shift keeps placing. Right click or Escape stop, clicks
+// only count when they reach the world. Report whether the placement mode
+// took the mouse.
+func (s *MouseFollower) updatePlacement(sys *UnitSpawner, mouse engo.Point, clicks bool) bool {
+	if engo.Input.Button(buildButton).JustPressed() && s.hasWorker() {
+		s.startPlacing(sys)
+	}
+	if s.placing == nil {
+		return false
+	}
+	if !s.hasWorker() || clicks && s.cursor.mouse.RightClicked || engo.Input.Button(cancelButton).JustPressed() {
+		s.stopPlacing()
+		return true
+	}
+
+	// The footprint is centered on the mouse, on whole tiles
+	corner := engo.Point{
+		X: float32(math.Round(float64(mouse.X-float32(s.placing.Width)/2)/discreteStep) * discreteStep),
+		Y: float32(math.Round(float64(mouse.Y-float32(s.placing.Height)/2)/discreteStep) * discreteStep),
+	}
+	valid := sys.Stock(sys.Player) >= s.placing.Cost && sys.CanPlace(s.placing, corner)
+	s.ghost.SpaceComponent = common.SpaceComponent{Position: corner, Width: float32(s.placing.Width), Height: float32(s.placing.Height)}
+	s.ghost.RenderComponent.Hidden = false
+	s.ghost.RenderComponent.Color = ghostInvalid
+	if valid {
+		s.ghost.RenderComponent.Color = ghostValid
+	}
+
+	if clicks && s.cursor.mouse.Clicked && valid {
+		sys.Issue(Command{Kind: OrderBuild, Type: s.placing.Name, Target: ToFixedPoint(corner), Team: sys.Player})
+		if !shiftDown() {
+			s.stopPlacing()
+		}
+	}
+	return true
+}
+
+// hasWorker check if a worker is selected
+func (s *MouseFollower) hasWorker() bool {
+	for _, unit := range s.selected {
+		if unit.CanGather() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package systems
+
+import (
+	"log"
+
+	"github.com/EngoEngine/engo"
+)
+
+// Production settings
+const (
+	// Units a building can have queued, the one in training included
+	maxQueue = 5
+)
+
+// Train order a building to train a unit of the named type, paid for from the
+// stock of its team
+func (us *UnitSpawner) Train(b *Building, unitType string) {
+	us.Issue(Command{Kind: OrderTrain, Building: b.id, Type: unitType, Team: b.team})
+}
+
+// SetRally send the units a building trains to a point. Workers sent to a
+// resource node start gathering it.
+func (us *UnitSpawner) SetRally(b *Building, target engo.Point) {
+	us.Issue(Command{Kind: OrderRally, Building: b.id, Target: ToFixedPoint(target), Team: b.team})
+}
+
+// Build put up a building of the named type owned by team, with its top left
+// corner at the given position, paid for from the stock of the team
+func (us *UnitSpawner) Build(buildingType string, corner engo.Point, team Team) {
+	us.Issue(Command{Kind: OrderBuild, Type: buildingType, Target: ToFixedPoint(corner), Team: team})
+}
+
+// ownBuilding the building a command is for, nil unless it belongs to the
+// team that issued the command
+func (us *UnitSpawner) ownBuilding(cmd Command) *Building {
+	b := us.building(cmd.Building)
+	if b == nil || b.team != cmd.Team {
+		return nil
+	}
+	return b
+}
+
+// train queue a unit in a building, if the building trains the type, has room
+// in its queue and the team can pay for it
+func (us *UnitSpawner) train(cmd Command) {
+	b := us.ownBuilding(cmd)
+	if b == nil || !b.kind.produces(cmd.Type) || len(b.queue) >= maxQueue {
+		return
+	}
+	t, err := us.Types.Lookup(cmd.Type)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if us.stock[b.team] < t.Cost {
+		return
+	}
+	us.stock[b.team] -= t.Cost
+	b.queue = append(b.queue, t)
+}
+
+// rally set the point the units of a building go to
+func (us *UnitSpawner) rally(cmd Command) {
+	if b := us.ownBuilding(cmd); b != nil {
+		b.rally, b.hasRally = cmd.Target, true
+	}
+}
+
+// build place a building, if it fits where the command puts it and the team
+// can pay for it
+func (us *UnitSpawner) build(cmd Command) {
+	t, err := us.BuildingTypes.Lookup(cmd.Type)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	corner := cmd.Target.Engo()
+	if us.stock[cmd.Team] < t.Cost || !us.CanPlace(t, corner) {
+		return
+	}
+	if _, err := us.PlaceBuilding(corner.X, corner.Y, t.Name, cmd.Team); err != nil {
+		log.Println(err)
+		return
+	}
+	us.stock[cmd.Team] -= t.Cost
+}
+
+// produce advance the training in every building, units that are done come
+// out of it
+func (us *UnitSpawner) produce() {
+	for _, b := range us.buildings {
+		if len(b.queue) == 0 {
+			continue
+		}
+		b.progress++
+		t := b.queue[0]
+		if b.progress < t.buildTicks {
+			continue
+		}
+		b.queue = b.queue[1:]
+		b.progress = 0
+		us.release(b, t)
+	}
+}
+
+// release spawn a trained unit on the side of the building that faces the
+// rally point, the bottom without one, and send it to the rally point
+func (us *UnitSpawner) release(b *Building, t *UnitType) {
+	exit := FixedPoint{b.rect.center().X, FixedFromInt(b.rect.y1*discreteStep + discreteStep)}
+	if b.hasRally {
+		exit = b.rally
+	}
+	spot := us.openSpot(b.rect.closest(exit)).Sub(FixedPoint{t.size.X / 2, t.size.Y / 2}).Engo()
+	unit, err := us.SpawnUnitAtLocation(spot.X, spot.Y, t.Name, b.team)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if !b.hasRally {
+		return
+	}
+	kind := OrderMove
+	if us.nodeAt(FixedToPathing(b.rally)) != nil {
+		kind = OrderGather
+	}
+	us.command([]*BasicUnit{unit}, nil, Command{Kind: kind, Target: b.rally})
+}
@@ -0,0 +1,219 @@
+package systems
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/EngoEngine/ecs"
+	"github.com/EngoEngine/engo"
+)
+
+// ReplayVersion version of the replay file format, replays of other versions
+// can not be played
+const ReplayVersion = 1
+
+// Replay a recorded game: the setup it started from and every command with
+// the tick it was carried out in. Replaying the commands on the same setup
+// plays out the same game, since the simulation is deterministic.
+type Replay struct {
+	Version   int            `json:"version"`
+	Level     string         `json:"level"`
+	UnitTypes string         `json:"unitTypes"`
+	Player    Team           `json:"player"`
+	Units     []ScenarioUnit `json:"units"`    // placed before the first tick
+	Log       []TickCommand  `json:"commands"` // in the order they were carried out
+	Ticks     int            `json:"ticks"`    // length of the game
+}
+
+// TickCommand command carried out at the start of a tick
+type TickCommand struct {
+	Tick int `json:"tick"`
+	Command
+}
+
+// NewReplay an empty replay of a game on the given level, to record into
+func NewReplay(level string, player Team) *Replay {
+	return &Replay{Version: ReplayVersion, Level: level, UnitTypes: UnitTypesURL, Player: player}
+}
+
+// add record a command
+func (r *Replay) add(tick int, cmd Command) {
+	r.Log = append(r.Log, TickCommand{Tick: tick, Command: cmd})
+}
+
+// Commands the recorded commands of a tick, false once the replay is over
+func (r *Replay) Commands(tick int) ([]Command, bool) {
+	if tick >= r.Ticks {
+		return nil, false
+	}
+	i := sort.Search(len(r.Log), func(i int) bool { return r.Log[i].Tick >= tick })
+	var cmds []Command
+	for ; i < len(r.Log) && r.Log[i].Tick == tick; i++ {
+		cmds = append(cmds, r.Log[i].Command)
+	}
+	return cmds, true
+}
+
+// Setup place the units the replay starts with
+func (r *Replay) Setup(us *UnitSpawner) error {
+	for _, unit := range r.Units {
+		if _, err := us.SpawnUnitAtLocation(unit.X, unit.Y, unit.Type, unit.Team); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Save write the replay as gzipped JSON
+func (r *Replay) Save(file string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(f)
+	if err := json.NewEncoder(zw).Encode(r); err != nil {
+		f.Close()
+		return fmt.Errorf("writing replay %s: %v", file, err)
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return fmt.Errorf("writing replay %s: %v", file, err)
+	}
+	return f.Close()
+}
+
+// LoadReplay read a replay written by Save
+func LoadReplay(file string) (*Replay, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading replay %s: %v", file, err)
+	}
+	var r Replay
+	if err := json.NewDecoder(zr).Decode(&r); err != nil {
+		return nil, fmt.Errorf("reading replay %s: %v", file, err)
+	}
+	if r.Version != ReplayVersion {
+		return nil, fmt.Errorf("replay %s has version %d, expected %d", file, r.Version, ReplayVersion)
+	}
+	sort.SliceStable(r.Log, func(i, j int) bool { return r.Log[i].Tick < r.Log[j].Tick })
+	return &r, nil
+}
+
+// Replay viewer settings
+const (
+	// Seconds the seek buttons jump
+	replaySeekStep = 10
+)
+
+// Names of the replay viewer buttons
+const (
+	replayPauseButton   = "ReplayPause"
+	replayBackButton    = "ReplayBack"
+	replayForwardButton = "ReplayForward"
+)
+
+// replaySpeeds playback speeds and the keys that pick them
+var replaySpeeds = []struct {
+	speed float32
+	key   engo.Key
+}{
+	{1, engo.KeyOne}, {2, engo.KeyTwo}, {4, engo.KeyFour},
+}
+
+// ReplayPlayer system that plays a replay on the UnitSpawner of the world,
+// which must take its commands from the replay. Space pauses, 1, 2 and 4 set
+// the speed, comma and period seek back and forward. Seeking back plays the
+// replay again from the start, as fast as possible.
+type ReplayPlayer struct {
+	Replay *Replay
+
+	spawner *UnitSpawner
+	ended   bool
+}
+
+// New register the viewer buttons and find the spawner
+func (p *ReplayPlayer) New(w *ecs.World) {
+	engo.Input.RegisterButton(replayPauseButton, engo.KeySpace)
+	engo.Input.RegisterButton(replayBackButton, engo.KeyComma)
+	engo.Input.RegisterButton(replayForwardButton, engo.KeyPeriod)
+	for _, speed := range replaySpeeds {
+		engo.Input.RegisterButton(replaySpeedButton(speed.speed), speed.key)
+	}
+	for _, system := range w.Systems() {
+		switch sys := system.(type) {
+		case *UnitSpawner:
+			p.spawner = sys
+		}
+	}
+}
+
+// replaySpeedButton name of the button that sets a playback speed
+func replaySpeedButton(speed float32) string {
+	return fmt.Sprintf("ReplaySpeed%g", speed)
+}
+
+// Remove does nothing, the player has no entities
+func (*ReplayPlayer) Remove(ecs.BasicEntity) {}
+
+// Update handle the viewer buttons
+func (p *ReplayPlayer) Update(dt float32) {
+	us := p.spawner
+	if us == nil {
+		return
+	}
+	if engo.Input.Button(replayPauseButton).JustPressed() {
+		us.Paused = !us.Paused
+	}
+	for _, speed := range replaySpeeds {
+		if engo.Input.Button(replaySpeedButton(speed.speed)).JustPressed() {
+			us.Speed = speed.speed
+			log.Printf("Replay speed %gx", speed.speed)
+		}
+	}
+	switch {
+	case engo.Input.Button(replayBackButton).JustPressed():
+		p.Seek(us.Ticks() - replaySeekStep*TickRate)
+	case engo.Input.Button(replayForwardButton).JustPressed():
+		p.Seek(us.Ticks() + replaySeekStep*TickRate)
+	}
+
+	ended := us.Ticks() >= p.Replay.Ticks
+	if ended && !p.ended {
+		log.Println("Replay over")
+	}
+	p.ended = ended
+}
+
+// Seek jump to a tick of the replay. Going back resets the simulation and runs
+// the replay from the start up to the tick.
+func (p *ReplayPlayer) Seek(tick int) {
+	us := p.spawner
+	if tick < 0 {
+		tick = 0
+	}
+	if tick > p.Replay.Ticks {
+		tick = p.Replay.Ticks
+	}
+	if tick < us.Ticks() {
+		us.Reset()
+		if err := p.Replay.Setup(us); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+	for us.Ticks() < tick {
+		if !us.Tick() {
+			break
+		}
+	}
+	log.Printf("Replay at %ds of %ds", us.Ticks()/TickRate, p.Replay.Ticks/TickRate)
+}
@@ -0,0 +1,97 @@
+package systems
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"log"
+
+	"github.com/EngoEngine/ecs"
+	"github.com/EngoEngine/engo"
+	"github.com/EngoEngine/engo/common"
+	"golang.org/x/image/font/gofont/gomono"
+)
+
+// Resource counter settings
+const (
+	// Name the built-in font is loaded under
+	counterFontURL = "gomono.ttf"
+	// Size of the text, in points
+	counterFontSize = 20
+	// Pixels between the counter and the top left corner of the window
+	counterMargin = 10
+)
+
+// counterLabel entity the counter is drawn with
+type counterLabel struct {
+	ecs.BasicEntity
+	common.RenderComponent
+	common.SpaceComponent
+}
+
+// ResourceCounter system that shows the resources the player gathered in the
+// top left corner of the window
+type ResourceCounter struct {
+	spawner *UnitSpawner
+	font    *common.Font
+	label   counterLabel
+	shown   int // stock the label shows, -1 before it is first drawn
+}
+
+// New load the font and create the label
+func (c *ResourceCounter) New(w *ecs.World) {
+	for _, system := range w.Systems() {
+		switch sys := system.(type) {
+		case *UnitSpawner:
+			c.spawner = sys
+		}
+	}
+	if c.spawner == nil {
+		return
+	}
+
+	if err := engo.Files.LoadReaderData(counterFontURL, bytes.NewReader(gomono.TTF)); err != nil {
+		log.Println(err)
+		return
+	}
+	c.font = &common.Font{URL: counterFontURL, FG: color.Black, Size: counterFontSize}
+	if err := c.font.CreatePreloaded(); err != nil {
+		log.Println(err)
+		c.font = nil
+		return
+	}
+
+	c.label = counterLabel{BasicEntity: ecs.NewBasic()}
+	c.label.SpaceComponent = common.SpaceComponent{Position: engo.Point{X: counterMargin, Y: counterMargin}}
+	c.label.RenderComponent.SetShader(common.HUDShader)
+	c.label.RenderComponent.SetZIndex(10)
+	c.shown = -1
+	c.draw()
+	for _, system := range w.Systems() {
+		switch sys := system.(type) {
+		case *common.RenderSystem:
+			sys.Add(&c.label.BasicEntity, &c.label.RenderComponent, &c.label.SpaceComponent)
+		}
+	}
+}
+
+// Remove does nothing, the counter stays for the whole scene
+func (*ResourceCounter) Remove(ecs.BasicEntity) {}
+
+// Update redraw the counter when the stock of the player changed
+func (c *ResourceCounter) Update(dt float32) {
+	if c.font == nil {
+		return
+	}
+	c.draw()
+}
+
+// draw write the stock of the player on the label, if it changed
+func (c *ResourceCounter) draw() {
+	stock := c.spawner.Stock(c.spawner.Player)
+	if stock == c.shown {
+		return
+	}
+	c.shown = stock
+	c.label.Drawable = common.Text{Font: c.font, Text: fmt.Sprintf("Resources: %d", stock)}
+}
@@ -0,0 +1,283 @@
+package systems
+
+import (
+	"image/color"
+	"log"
+	"sort"
+
+	"github.com/EngoEngine/ecs"
+	"github.com/EngoEngine/engo"
+	"github.com/EngoEngine/engo/common"
+)
+
+// Gathering settings
+const (
+	// Pixels between the body of a worker and a node or drop-off it works at
+	gatherReach = 16
+	// A worker whose node runs out moves on to the nearest node this many
+	// pixels around it
+	gatherSearch = 320
+	// Walks in a row a worker makes towards a node or drop-off it does not
+	// end up next to, before it gives up
+	maxGatherTries = 3
+	// Size of resource nodes that are placed as points, in pixels
+	defaultResourceSize = 32
+)
+
+// resourceColor colour of the resource nodes
+var resourceColor = color.RGBA{230, 190, 40, 255}
+
+// ResourceNode deposit in the world that workers harvest. It blocks its
+// footprint until it runs out.
+type ResourceNode struct {
+	ecs.BasicEntity
+	common.RenderComponent
+	common.SpaceComponent
+	// Amount of resources left
+	Amount int
+
+	rect  tileRect // footprint
+	under []int    // weights of the footprint tiles before it was blocked
+}
+
+// Nodes the resource nodes, including the ones that ran out
+func (us *UnitSpawner) Nodes() []*ResourceNode {
+	return us.nodes
+}
+
+// Stock the resources a team gathered
+func (us *UnitSpawner) Stock(team Team) int {
+	return us.stock[team]
+}
+
+// PlaceResource put a resource node with its top left corner at the given
+// position, blocking its footprint
+func (us *UnitSpawner) PlaceResource(x, y, width, height float32, amount int) *ResourceNode {
+	node := &ResourceNode{BasicEntity: ecs.NewBasic(), Amount: amount, rect: resourceRect(x, y, width, height)}
+	us.addNode(node)
+	return node
+}
+
+// resourceRect the tiles a node covers with its top left corner at the given
+// position, every tile it touches counts
+func resourceRect(x, y, width, height float32) tileRect {
+	corner := EngoToPathing(engo.Point{X: x, Y: y})
+	end := EngoToPathing(engo.Point{X: x + width + discreteStep - 1, Y: y + height + discreteStep - 1})
+	return tileRect{corner.X, corner.Y, end.X, end.Y}
+}
+
+// addNode block the footprint of a node and draw it, nodes that ran out are
+// only kept in the list
+func (us *UnitSpawner) addNode(node *ResourceNode) {
+	us.nodes = append(us.nodes, node)
+	if node.Amount <= 0 {
+		return
+	}
+	node.under = node.rect.block(us.ast)
+	if us.world == nil {
+		return
+	}
+	node.RenderComponent = common.RenderComponent{Drawable: common.Rectangle{}, Color: resourceColor}
+	node.RenderComponent.SetZIndex(-0.5)
+	node.SpaceComponent = node.rect.space()
+	for _, system := range us.world.Systems() {
+		switch sys := system.(type) {
+		case *common.RenderSystem:
+			sys.Add(&node.BasicEntity, &node.RenderComponent, &node.SpaceComponent)
+		}
+	}
+}
+
+// deplete free the footprint of a node that ran out and stop drawing it
+func (us *UnitSpawner) deplete(node *ResourceNode) {
+	node.Amount = 0
+	node.rect.unblock(us.ast, node.under)
+	if us.world != nil {
+		us.world.RemoveEntity(node.BasicEntity)
+	}
+}
+
+// nodeAt the node with resources left that covers a tile, or nil
+func (us *UnitSpawner) nodeAt(p Point) *ResourceNode {
+	for _, node := range us.nodes {
+		if node.Amount > 0 && node.rect.contains(p) {
+			return node
+		}
+	}
+	return nil
+}
+
+// nearestNode the node with resources left closest to p, within the given
+// distance, or nil
+func (us *UnitSpawner) nearestNode(p FixedPoint, within Fixed) *ResourceNode {
+	var nearest *ResourceNode
+	for _, node := range us.nodes {
+		if node.Amount <= 0 {
+			continue
+		}
+		if dist := node.rect.dist(p); dist <= within {
+			nearest, within = node, dist
+		}
+	}
+	return nearest
+}
+
+// nodeIndex the place of a node in the list, which identifies it in saves,
+// -1 for none
+func (us *UnitSpawner) nodeIndex(node *ResourceNode) int {
+	for i, n := range us.nodes {
+		if n == node {
+			return i
+		}
+	}
+	return -1
+}
+
+// Gather order units to harvest a resource node, workers bring what they
+// gather to the nearest drop-off of their team until the node runs out
+func (us *UnitSpawner) Gather(units []*BasicUnit, node *ResourceNode) {
+	us.Issue(Command{Units: unitIDs(units), Kind: OrderGather, Target: node.rect.center()})
+}
+
+// Gather order the unit to harvest a resource node
+func (unit *BasicUnit) Gather(us *UnitSpawner, node *ResourceNode) {
+	us.Gather([]*BasicUnit{unit}, node)
+}
+
+// CanGather check if the unit is a worker
+func (unit *BasicUnit) CanGather() bool {
+	return unit.kind.Capacity > 0
+}
+
+// gather carry out the gather order of a worker for a tick: walk to the node,
+// harvest it, bring the load to a drop-off and go back. The order ends, with
+// its node set to nil, once there is nothing left to gather around or the
+// worker can not get where it has to be.
+func (us *UnitSpawner) gather(unit *BasicUnit, order *Order) {
+	node := order.node
+	if node.Amount <= 0 && unit.carrying == 0 {
+		// The node ran out, move on to the nearest one around it
+		if node = us.nearestNode(node.rect.center(), FixedFromInt(gatherSearch)); node == nil {
+			order.node = nil
+			return
+		}
+		order.node = node
+		unit.gatherTries = 0
+	}
+
+	if unit.carrying >= unit.kind.Capacity || node.Amount <= 0 {
+		depot := us.dropOff(unit)
+		if depot == nil {
+			order.node = nil
+			return
+		}
+		switch us.approach(unit, depot.rect) {
+		case approachFailed:
+			order.node = nil
+		case approachArrived:
+			us.stock[unit.team] += unit.carrying
+			unit.carrying = 0
+		}
+		return
+	}
+
+	switch us.approach(unit, node.rect) {
+	case approachFailed:
+		order.node = nil
+		return
+	case approachWalking:
+		return
+	}
+	if unit.harvestLeft == 0 {
+		unit.harvestLeft = unit.kind.gatherTicks
+	}
+	unit.harvestLeft--
+	if unit.harvestLeft > 0 {
+		return
+	}
+	take := unit.kind.Capacity - unit.carrying
+	if take > node.Amount {
+		take = node.Amount
+	}
+	unit.carrying += take
+	node.Amount -= take
+	if node.Amount == 0 {
+		us.deplete(node)
+	}
+}
+
+// Outcomes of walking up to a footprint
+const (
+	approachWalking = iota
+	approachArrived
+	approachFailed
+)
+
+// approach walk the unit up to a footprint. A unit that stopped without
+// reaching it walks there again, up to maxGatherTries times.
+func (us *UnitSpawner) approach(unit *BasicUnit, rect tileRect) int {
+	if rect.dist(unit.pos) <= unit.radius()+FixedFromInt(gatherReach) {
+		if unit.moving() || us.paths.Pending(unit) {
+			unit.stop()
+			us.paths.Cancel(unit)
+		}
+		unit.gatherTries = 0
+		return approachArrived
+	}
+	if unit.moving() || us.paths.Pending(unit) {
+		return approachWalking
+	}
+	if unit.gatherTries == maxGatherTries {
+		return approachFailed
+	}
+	unit.gatherTries++
+	unit.harvestLeft = 0
+	us.paths.Request(unit, us.openSpot(rect.closest(unit.pos)))
+	return approachWalking
+}
+
+// placeLevelEconomy put the resource nodes and buildings of the level on the
+// grid
+func (us *UnitSpawner) placeLevelEconomy() {
+	if us.Level == nil {
+		return
+	}
+	for _, r := range us.Level.Resources {
+		us.PlaceResource(r.Position.X, r.Position.Y, r.Width, r.Height, r.Amount)
+	}
+	for _, b := range us.Level.Buildings {
+		if _, err := us.PlaceBuilding(b.Position.X, b.Position.Y, b.Building, b.Team); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// clearEconomy remove the nodes and buildings and forget the stock of every
+// team
+func (us *UnitSpawner) clearEconomy() {
+	if us.world != nil {
+		for _, node := range us.nodes {
+			if node.Amount > 0 {
+				us.world.RemoveEntity(node.BasicEntity)
+			}
+		}
+		for _, b := range us.buildings {
+			us.world.RemoveEntity(b.BasicEntity)
+			us.world.RemoveEntity(b.healthBar.BasicEntity)
+		}
+	}
+	us.nodes = nil
+	us.buildings = nil
+	us.lastBuilding = 0
+	us.stock = make(map[Team]int)
+}
+
+// stockTeams the teams that have a stock, sorted
+func (us *UnitSpawner) stockTeams() []Team {
+	teams := make([]Team, 0, len(us.stock))
+	for team := range us.stock {
+		teams = append(teams, team)
+	}
+	sort.Slice(teams, func(i, j int) bool { return teams[i] < teams[j] })
+	return teams
+}
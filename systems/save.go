@@ -0,0 +1,568 @@
+package systems
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/EngoEngine/ecs"
+)
+
+// SaveVersion version of the save file format. Saves of older versions are
+// upgraded by saveMigrations when they are loaded.
+const SaveVersion = 3
+
+// saveMigrations upgrade the raw fields of a save from the version it is
+// indexed by to the next one. Add one whenever SaveVersion goes up.
+var saveMigrations = map[int]func(fields map[string]json.RawMessage) error{
+	1: addLevelEconomy,
+	2: addBuildingHP,
+}
+
+// addLevelEconomy give saves from before the economy the resource nodes and
+// buildings of their level. Saves that already list their nodes are left as
+// they are, even when the list is empty.
+func addLevelEconomy(fields map[string]json.RawMessage) error {
+	if _, ok := fields["nodes"]; ok {
+		return nil
+	}
+	var url string
+	if err := json.Unmarshal(fields["level"], &url); err != nil || url == "" {
+		return err
+	}
+	level, err := LoadLevel(url)
+	if err != nil {
+		return err
+	}
+	var nodes []SavedNode
+	for _, r := range level.Resources {
+		rect := resourceRect(r.Position.X, r.Position.Y, r.Width, r.Height)
+		nodes = append(nodes, SavedNode{X: rect.x0, Y: rect.y0, Width: rect.x1 - rect.x0, Height: rect.y1 - rect.y0, Amount: r.Amount})
+	}
+	var buildings []SavedBuilding
+	for _, b := range level.Buildings {
+		corner := EngoToPathing(b.Position)
+		buildings = append(buildings, SavedBuilding{Type: b.Building, Team: b.Team, X: corner.X, Y: corner.Y})
+	}
+	if fields["nodes"], err = json.Marshal(nodes); err != nil {
+		return err
+	}
+	fields["buildings"], err = json.Marshal(buildings)
+	return err
+}
+
+// addBuildingHP give the buildings of saves from before buildings could be
+// attacked the hit points of their type. Buildings that have hit points keep
+// them, a razed building is never saved so 0 means there were none. Saves
+// without buildings, such as those without a level, have nothing to fill in.
+func addBuildingHP(fields map[string]json.RawMessage) error {
+	raw, ok := fields["buildings"]
+	if !ok {
+		return nil
+	}
+	var buildings []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &buildings); err != nil || len(buildings) == 0 {
+		return err
+	}
+	types, err := LoadBuildingTypes(BuildingTypesURL)
+	if err != nil {
+		return err
+	}
+	for _, b := range buildings {
+		var hp int
+		if raw, ok := b["hp"]; ok {
+			if err := json.Unmarshal(raw, &hp); err != nil {
+				return err
+			}
+		}
+		if hp > 0 {
+			continue
+		}
+		var name string
+		if err := json.Unmarshal(b["type"], &name); err != nil {
+			return err
+		}
+		t, err := types.Lookup(name)
+		if err != nil {
+			return err
+		}
+		if b["hp"], err = json.Marshal(t.HP); err != nil {
+			return err
+		}
+	}
+	fields["buildings"], err = json.Marshal(buildings)
+	return err
+}
+
+// SaveGame everything needed to continue a game where it was saved
+type SaveGame struct {
+	Version   int    `json:"version"`
+	Level     string `json:"level"`
+	UnitTypes string `json:"unitTypes"`
+
+	Tick      int         `json:"tick"`
+	LastID    UnitID      `json:"lastID"`
+	Player    Team        `json:"player"`
+	Formation Formation   `json:"formation"`
+	Allies    [][2]Team   `json:"allies"`
+	Tiles     []SavedTile `json:"tiles"` // filled tiles of the pathing grid
+	Units     []SavedUnit `json:"units"`
+	Paths     []SavedPath `json:"paths"`    // searches the units are waiting for
+	Commands  []Command   `json:"commands"` // issued for the next tick
+	Camera    SavedCamera `json:"camera"`
+	Groups    [][]UnitID  `json:"groups"` // control groups of the player
+
+	Vision map[Team][]Visibility `json:"vision"` // what every team has explored
+
+	Stock        map[Team]int    `json:"stock"` // resources gathered by every team
+	Nodes        []SavedNode     `json:"nodes"`
+	Buildings    []SavedBuilding `json:"buildings"`
+	LastBuilding BuildingID      `json:"lastBuilding"`
+}
+
+// SavedTile filled tile of the pathing grid
+type SavedTile struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Weight int `json:"weight"`
+}
+
+// SavedNode resource node, its footprint in pathing tiles
+type SavedNode struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	Amount int `json:"amount"`
+}
+
+// SavedBuilding building, its footprint in pathing tiles
+type SavedBuilding struct {
+	ID       BuildingID  `json:"id"`
+	Type     string      `json:"type"`
+	Team     Team        `json:"team"`
+	X        int         `json:"x"`
+	Y        int         `json:"y"`
+	HP       int         `json:"hp"`
+	Queue    []string    `json:"queue"` // unit types to train, the one in training first
+	Progress int         `json:"progress"`
+	Rally    *FixedPoint `json:"rally,omitempty"`
+}
+
+// SavedUnit state of a unit
+type SavedUnit struct {
+	ID       UnitID     `json:"id"`
+	Type     string     `json:"type"`
+	Team     Team       `json:"team"`
+	Selected bool       `json:"selected"`
+	Pos      FixedPoint `json:"pos"`
+	PrevPos  FixedPoint `json:"prevPos"`
+	Pace     Fixed      `json:"pace"`
+	Path     []Point    `json:"path"`           // waypoints left, the next one first
+	Flow     *Point     `json:"flow,omitempty"` // target of the flow field the unit follows
+	Goal     Point      `json:"goal"`
+	Heading  FixedPoint `json:"heading"`
+
+	Repath     bool        `json:"repath"` // the path crosses tiles that changed since it was checked
+	BlockedFor int         `json:"blockedFor"`
+	Repaths    int         `json:"repaths"`
+	Stuck      StuckReason `json:"stuck"`
+
+	HP         int          `json:"hp"`
+	Cooldown   int          `json:"cooldown"`
+	Target     UnitID       `json:"target"`
+	Siege      BuildingID   `json:"siege"`
+	ChaseTile  Point        `json:"chaseTile"`
+	AttackMove bool         `json:"attackMove"`
+	AttackGoal FixedPoint   `json:"attackGoal"`
+	Hold       bool         `json:"hold"`
+	Orders     []SavedOrder `json:"orders"`
+
+	Carrying    int `json:"carrying"`
+	HarvestLeft int `json:"harvestLeft"`
+	GatherTries int `json:"gatherTries"`
+}
+
+// SavedOrder entry in the order queue of a unit
+type SavedOrder struct {
+	Kind     OrderKind  `json:"kind"`
+	Target   FixedPoint `json:"target"`
+	Unit     UnitID     `json:"unit"`
+	Building BuildingID `json:"building"`
+	Node     int        `json:"node"` // place of the node to gather in the list plus one, 0 for none
+	Pace     Fixed      `json:"pace"`
+	From     FixedPoint `json:"from"`
+	Started  bool       `json:"started"`
+}
+
+// SavedPath path search that units are waiting for
+type SavedPath struct {
+	Units  []UnitID `json:"units"`
+	Source []Point  `json:"source"`
+	Target []Point  `json:"target"`
+	Flow   bool     `json:"flow"`
+}
+
+// SavedCamera where the camera looks
+type SavedCamera struct {
+	X float32 `json:"x"`
+	Y float32 `json:"y"`
+	Z float32 `json:"z"`
+}
+
+// WriteSave write a save as JSON, creating its directory if needed
+func WriteSave(file string, save *SaveGame) error {
+	data, err := json.Marshal(save)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0644)
+}
+
+// ReadSave read a save, upgrading it if it is of an older version
+func ReadSave(file string) (*SaveGame, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("reading save %s: %v", file, err)
+	}
+	var version int
+	if err := json.Unmarshal(fields["version"], &version); err != nil {
+		return nil, fmt.Errorf("reading save %s: no version", file)
+	}
+	if version > SaveVersion {
+		return nil, fmt.Errorf("save %s has version %d, newer than %d", file, version, SaveVersion)
+	}
+	for ; version < SaveVersion; version++ {
+		migrate, ok := saveMigrations[version]
+		if !ok {
+			return nil, fmt.Errorf("save %s has version %d, which can not be upgraded", file, version)
+		}
+		if err := migrate(fields); err != nil {
+			return nil, fmt.Errorf("upgrading save %s from version %d: %v", file, version, err)
+		}
+	}
+	fields["version"], _ = json.Marshal(SaveVersion)
+
+	if data, err = json.Marshal(fields); err != nil {
+		return nil, err
+	}
+	var save SaveGame
+	if err := json.Unmarshal(data, &save); err != nil {
+		return nil, fmt.Errorf("reading save %s: %v", file, err)
+	}
+	return &save, nil
+}
+
+// Save the state of the simulation. The camera and control groups belong to
+// the player and are left to the caller.
+func (us *UnitSpawner) Save() *SaveGame {
+	save := &SaveGame{
+		Version:   SaveVersion,
+		UnitTypes: us.Types.URL,
+		Tick:      us.tick,
+		LastID:    us.lastID,
+		Player:    us.Player,
+		Formation: us.Formation,
+		Allies:    us.Diplomacy.Alliances(),
+		Commands:  append([]Command(nil), us.commands...),
+		Paths:     us.paths.save(),
+		Vision:    make(map[Team][]Visibility, len(us.vision)),
+		Stock:     make(map[Team]int, len(us.stock)),
+	}
+	for team, vision := range us.vision {
+		save.Vision[team] = append([]Visibility(nil), vision.tiles...)
+	}
+	if us.Level != nil {
+		save.Level = us.Level.URL
+	}
+	for team, stock := range us.stock {
+		save.Stock[team] = stock
+	}
+	for _, node := range us.nodes {
+		r := node.rect
+		save.Nodes = append(save.Nodes, SavedNode{X: r.x0, Y: r.y0, Width: r.x1 - r.x0, Height: r.y1 - r.y0, Amount: node.Amount})
+	}
+	for _, b := range us.buildings {
+		saved := SavedBuilding{
+			ID:       b.id,
+			Type:     b.kind.Name,
+			Team:     b.team,
+			X:        b.rect.x0,
+			Y:        b.rect.y0,
+			HP:       b.hp,
+			Progress: b.progress,
+		}
+		for _, t := range b.queue {
+			saved.Queue = append(saved.Queue, t.Name)
+		}
+		if b.hasRally {
+			rally := b.rally
+			saved.Rally = &rally
+		}
+		save.Buildings = append(save.Buildings, saved)
+	}
+	save.LastBuilding = us.lastBuilding
+
+	if grid, ok := us.ast.(Grid); ok {
+		rows, cols := grid.Size()
+		for x := 0; x < rows; x++ {
+			for y := 0; y < cols; y++ {
+				if weight := grid.Weight(Point{X: x, Y: y}); weight != 0 {
+					save.Tiles = append(save.Tiles, SavedTile{X: x, Y: y, Weight: weight})
+				}
+			}
+		}
+	}
+
+	grid, _ := us.ast.(Grid)
+	for _, unit := range us.AliveUnits {
+		saved := SavedUnit{
+			ID:         unit.id,
+			Type:       unit.kind.Name,
+			Team:       unit.team,
+			Selected:   unit.selected,
+			Pos:        unit.pos,
+			PrevPos:    unit.prevPos,
+			Pace:       unit.pace,
+			Goal:       unit.goal,
+			Heading:    unit.heading,
+			Repath:     grid != nil && unit.pathChanged(grid),
+			BlockedFor: unit.blockedFor,
+			Repaths:    unit.repaths,
+			Stuck:      unit.stuck,
+			HP:         unit.hp,
+			Cooldown:   unit.cooldownLeft,
+			Target:     unit.target.UnitID(),
+			Siege:      unit.siege.BuildingID(),
+			ChaseTile:  unit.chaseTile,
+			AttackMove: unit.attackMove,
+			AttackGoal: unit.attackGoal,
+			Hold:       unit.hold,
+
+			Carrying:    unit.carrying,
+			HarvestLeft: unit.harvestLeft,
+			GatherTries: unit.gatherTries,
+		}
+		for p := unit.path; p != nil; p = p.Parent {
+			saved.Path = append(saved.Path, p.Point)
+		}
+		if unit.flow != nil {
+			target := unit.flow.Target
+			saved.Flow = &target
+		}
+		for _, order := range unit.orders {
+			saved.Orders = append(saved.Orders, SavedOrder{
+				Kind:     order.Kind,
+				Target:   order.Target,
+				Unit:     order.Unit.UnitID(),
+				Building: order.building.BuildingID(),
+				Node:     us.nodeIndex(order.node) + 1,
+				Pace:     order.pace,
+				From:     order.from,
+				Started:  order.started,
+			})
+		}
+		save.Units = append(save.Units, saved)
+	}
+	return save
+}
+
+// Load replace the simulation with a saved one. The save must be of the level
+// of the spawner.
+func (us *UnitSpawner) Load(save *SaveGame) error {
+	level := ""
+	if us.Level != nil {
+		level = us.Level.URL
+	}
+	if save.Level != level {
+		return fmt.Errorf("the save is of level %q, not %q", save.Level, level)
+	}
+	types := make(map[UnitID]*UnitType, len(save.Units))
+	for _, saved := range save.Units {
+		t, err := us.Types.Lookup(saved.Type)
+		if err != nil {
+			return err
+		}
+		types[saved.ID] = t
+	}
+	buildings := make([]*BuildingType, len(save.Buildings))
+	queues := make([][]*UnitType, len(save.Buildings))
+	for i, saved := range save.Buildings {
+		t, err := us.BuildingTypes.Lookup(saved.Type)
+		if err != nil {
+			return err
+		}
+		buildings[i] = t
+		for _, name := range saved.Queue {
+			unitType, err := us.Types.Lookup(name)
+			if err != nil {
+				return err
+			}
+			queues[i] = append(queues[i], unitType)
+		}
+	}
+
+	us.clear()
+	us.newGrid()
+	for _, tile := range save.Tiles {
+		us.ast.FillTile(Point{X: tile.X, Y: tile.Y}, tile.Weight)
+	}
+	us.tick = save.Tick
+	us.Player = save.Player
+	us.Formation = save.Formation
+	us.Diplomacy = Diplomacy{}
+	for _, pair := range save.Allies {
+		us.Diplomacy.Ally(pair[0], pair[1])
+	}
+	us.commands = save.Commands
+	for _, saved := range save.Nodes {
+		rect := tileRect{saved.X, saved.Y, saved.X + saved.Width, saved.Y + saved.Height}
+		us.addNode(&ResourceNode{BasicEntity: ecs.NewBasic(), Amount: saved.Amount, rect: rect})
+	}
+	for i, saved := range save.Buildings {
+		t := buildings[i]
+		b := &Building{
+			BasicEntity: ecs.NewBasic(),
+			id:          saved.ID,
+			kind:        t,
+			team:        saved.Team,
+			rect:        footprint(t, Point{X: saved.X, Y: saved.Y}),
+			hp:          saved.HP,
+			queue:       queues[i],
+			progress:    saved.Progress,
+		}
+		if saved.Rally != nil {
+			b.rally, b.hasRally = *saved.Rally, true
+		}
+		us.addBuilding(b)
+	}
+	if save.LastBuilding > us.lastBuilding {
+		us.lastBuilding = save.LastBuilding
+	}
+	for team, stock := range save.Stock {
+		us.stock[team] = stock
+	}
+	var version uint64
+	if grid, ok := us.ast.(Grid); ok {
+		version = grid.Version()
+		rows, cols := grid.Size()
+		for team, tiles := range save.Vision {
+			if len(tiles) == rows*cols {
+				vision := newVisionGrid(rows, cols)
+				copy(vision.tiles, tiles)
+				us.vision[team] = vision
+			}
+		}
+	}
+	// The game no longer follows from the recorded setup
+	us.Record = nil
+
+	for _, saved := range save.Units {
+		unit := &BasicUnit{BasicEntity: ecs.NewBasic(), id: saved.ID, team: saved.Team}
+		unit.pos, unit.prevPos = saved.Pos, saved.PrevPos
+		if err := us.setUnitParameters(unit, types[saved.ID]); err != nil {
+			return err
+		}
+		unit.pace = saved.Pace
+		for i := len(saved.Path) - 1; i >= 0; i-- {
+			unit.path = &PathPoint{Point: saved.Path[i], Parent: unit.path}
+		}
+		if fielder, ok := us.ast.(FlowFielder); ok && saved.Flow != nil {
+			unit.flow = fielder.FlowField(*saved.Flow)
+		}
+		unit.goal = saved.Goal
+		unit.heading = saved.Heading
+		unit.pathVersion = version
+		if saved.Repath {
+			unit.pathVersion = stalePath
+		}
+		unit.blockedFor = saved.BlockedFor
+		unit.repaths = saved.Repaths
+		unit.stuck = saved.Stuck
+		unit.hp = saved.HP
+		unit.cooldownLeft = saved.Cooldown
+		unit.chaseTile = saved.ChaseTile
+		unit.attackMove = saved.AttackMove
+		unit.attackGoal = saved.AttackGoal
+		unit.hold = saved.Hold
+		unit.carrying = saved.Carrying
+		unit.harvestLeft = saved.HarvestLeft
+		unit.gatherTries = saved.GatherTries
+		unit.showHealth()
+		if saved.Selected {
+			unit.Select()
+		}
+		us.addUnit(unit)
+	}
+	us.lastID = save.LastID
+
+	// Units refer to each other by ID, now that they all exist
+	for _, saved := range save.Units {
+		unit := us.units[saved.ID]
+		unit.target = us.units[saved.Target]
+		unit.siege = us.building(saved.Siege)
+		for _, o := range saved.Orders {
+			order := &Order{Kind: o.Kind, Target: o.Target, pace: o.Pace, from: o.From, started: o.Started}
+			if o.Kind == OrderAttack && o.Building != 0 {
+				order.building = us.building(o.Building)
+			}
+			if o.Kind == OrderAttack && order.building == nil {
+				order.Unit = us.units[o.Unit]
+				if order.Unit == nil {
+					// The target died, the order is dropped once it is its turn
+					order.Unit = &BasicUnit{}
+				}
+			}
+			if o.Node > 0 && o.Node <= len(us.nodes) {
+				order.node = us.nodes[o.Node-1]
+			}
+			unit.orders = append(unit.orders, order)
+		}
+	}
+	us.paths.restore(save.Paths, us.units)
+	return nil
+}
+
+// save the searches units are still waiting for, in the order they are
+// delivered
+func (ps *PathService) save() []SavedPath {
+	var saved []SavedPath
+	for _, reqs := range [][]*PathRequest{ps.inflight, ps.submitted} {
+		for _, req := range reqs {
+			var ids []UnitID
+			for _, unit := range req.units {
+				if ps.pending[unit] == req {
+					ids = append(ids, unit.id)
+				}
+			}
+			if len(ids) > 0 {
+				saved = append(saved, SavedPath{Units: ids, Source: req.source, Target: req.target, Flow: req.flow})
+			}
+		}
+	}
+	return saved
+}
+
+// restore request saved searches again, they are delivered at the next tick
+func (ps *PathService) restore(saved []SavedPath, units map[UnitID]*BasicUnit) {
+	for _, path := range saved {
+		req := &PathRequest{source: path.Source, target: path.Target, flow: path.Flow}
+		for _, id := range path.Units {
+			if unit, ok := units[id]; ok {
+				req.units = append(req.units, unit)
+			}
+		}
+		if len(req.units) > 0 {
+			ps.submit(req)
+		}
+	}
+	ps.Flush()
+}
@@ -0,0 +1,224 @@
+package systems
+
+import (
+	"fmt"
+	"image/color"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/EngoEngine/ecs"
+	"github.com/EngoEngine/engo"
+	"github.com/EngoEngine/engo/common"
+)
+
+// Save menu settings
+const (
+	// Directory saves are written to when SaveMenu.Dir is empty
+	defaultSaveDir = "saves"
+	// Number of save slots in the menu
+	saveSlots = 8
+	// Size of a slot box and the space between them, in pixels on screen
+	saveSlotSize = 64
+	saveSlotGap  = 16
+)
+
+// Names of the save buttons
+const (
+	quickSaveButton = "QuickSave"
+	quickLoadButton = "QuickLoad"
+	saveMenuButton  = "SaveMenu"
+)
+
+// Colours of the save slots
+var (
+	emptySlotColor = color.RGBA{120, 120, 120, 200}
+	usedSlotColor  = color.RGBA{40, 160, 60, 220}
+)
+
+// saveSlot box in the save menu
+type saveSlot struct {
+	ecs.BasicEntity
+	common.RenderComponent
+	common.SpaceComponent
+	common.MouseComponent
+}
+
+// SaveMenu system that saves and loads the game. F5 quicksaves and F9
+// quickloads. F6 opens the menu, which pauses the game and shows a row of
+// slots: a green slot holds a save, left click loads it, right click saves
+// into the slot.
+type SaveMenu struct {
+	// Dir directory the saves are kept in
+	Dir string
+
+	world   *ecs.World
+	spawner *UnitSpawner
+	mouse   *MouseFollower
+	camera  *CameraControl
+
+	open      bool
+	wasPaused bool
+	slots     []*saveSlot
+}
+
+// New register the save buttons and create the menu
+func (m *SaveMenu) New(w *ecs.World) {
+	m.world = w
+	if m.Dir == "" {
+		m.Dir = defaultSaveDir
+	}
+	engo.Input.RegisterButton(quickSaveButton, engo.KeyF5)
+	engo.Input.RegisterButton(quickLoadButton, engo.KeyF9)
+	engo.Input.RegisterButton(saveMenuButton, engo.KeyF6)
+
+	for _, system := range w.Systems() {
+		switch sys := system.(type) {
+		case *UnitSpawner:
+			m.spawner = sys
+		case *MouseFollower:
+			m.mouse = sys
+		case *CameraControl:
+			m.camera = sys
+		}
+	}
+
+	// A row of slots in the middle of the screen
+	width := float32(saveSlots*saveSlotSize + (saveSlots-1)*saveSlotGap)
+	left := (engo.GameWidth() - width) / 2
+	top := (engo.GameHeight() - saveSlotSize) / 2
+	for i := 0; i < saveSlots; i++ {
+		slot := &saveSlot{BasicEntity: ecs.NewBasic()}
+		slot.SpaceComponent = common.SpaceComponent{
+			Position: engo.Point{X: left + float32(i*(saveSlotSize+saveSlotGap)), Y: top},
+			Width:    saveSlotSize,
+			Height:   saveSlotSize,
+		}
+		slot.RenderComponent = common.RenderComponent{Drawable: common.Rectangle{}, Hidden: true}
+		slot.RenderComponent.SetShader(common.HUDShader)
+		slot.RenderComponent.SetZIndex(10)
+		for _, system := range w.Systems() {
+			switch sys := system.(type) {
+			case *common.RenderSystem:
+				sys.Add(&slot.BasicEntity, &slot.RenderComponent, &slot.SpaceComponent)
+			case *common.MouseSystem:
+				sys.Add(&slot.BasicEntity, &slot.MouseComponent, &slot.SpaceComponent, &slot.RenderComponent)
+			}
+		}
+		m.slots = append(m.slots, slot)
+	}
+}
+
+// Remove does nothing, the slots stay for the whole scene
+func (*SaveMenu) Remove(ecs.BasicEntity) {}
+
+// Open check if the menu is shown, it takes the mouse while it is
+func (m *SaveMenu) Open() bool {
+	return m.open
+}
+
+// slotFile the file of a save slot
+func (m *SaveMenu) slotFile(slot int) string {
+	return filepath.Join(m.Dir, fmt.Sprintf("slot%d.json", slot+1))
+}
+
+// quickFile the file of the quicksave
+func (m *SaveMenu) quickFile() string {
+	return filepath.Join(m.Dir, "quicksave.json")
+}
+
+// show open or close the menu, the game is paused while it is open
+func (m *SaveMenu) show(open bool) {
+	if open == m.open {
+		return
+	}
+	m.open = open
+	if open {
+		m.wasPaused = m.spawner.Paused
+		m.spawner.Paused = true
+		m.colorSlots()
+	} else {
+		m.spawner.Paused = m.wasPaused
+	}
+	for _, slot := range m.slots {
+		slot.RenderComponent.Hidden = !open
+	}
+}
+
+// colorSlots colour the slots by whether they hold a save
+func (m *SaveMenu) colorSlots() {
+	for i, slot := range m.slots {
+		if _, err := os.Stat(m.slotFile(i)); err == nil {
+			slot.RenderComponent.Color = usedSlotColor
+		} else {
+			slot.RenderComponent.Color = emptySlotColor
+		}
+	}
+}
+
+// Update handle the save buttons and clicks on the slots
+func (m *SaveMenu) Update(dt float32) {
+	if m.spawner == nil {
+		return
+	}
+	switch {
+	case engo.Input.Button(quickSaveButton).JustPressed():
+		m.save(m.quickFile())
+	case engo.Input.Button(quickLoadButton).JustPressed():
+		m.load(m.quickFile())
+	case engo.Input.Button(saveMenuButton).JustPressed():
+		m.show(!m.open)
+	}
+	if !m.open {
+		return
+	}
+	for i, slot := range m.slots {
+		switch {
+		case slot.MouseComponent.Clicked:
+			if _, err := os.Stat(m.slotFile(i)); err == nil {
+				m.load(m.slotFile(i))
+				m.show(false)
+			}
+		case slot.MouseComponent.RightClicked:
+			m.save(m.slotFile(i))
+			m.colorSlots()
+		}
+	}
+}
+
+// save write the game, with the camera and control groups, to a file
+func (m *SaveMenu) save(file string) {
+	save := m.spawner.Save()
+	if camera := findCamera(m.world); camera != nil {
+		save.Camera = SavedCamera{X: camera.X(), Y: camera.Y(), Z: camera.Z()}
+	}
+	if m.mouse != nil {
+		save.Groups = m.mouse.saveGroups()
+	}
+	if err := WriteSave(file, save); err != nil {
+		log.Println(err)
+		return
+	}
+	log.Println("Saved", file)
+}
+
+// load continue the game saved in a file
+func (m *SaveMenu) load(file string) {
+	save, err := ReadSave(file)
+	if err == nil {
+		err = m.spawner.Load(save)
+	}
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if center := (engo.Point{X: save.Camera.X, Y: save.Camera.Y}); m.camera != nil && save.Camera.Z > 0 {
+		m.camera.LookAt(center, save.Camera.Z)
+	} else if save.Camera.Z > 0 {
+		CenterCamera(center)
+	}
+	if m.mouse != nil {
+		m.mouse.restoreSelection(m.spawner, save.Groups)
+	}
+	log.Println("Loaded", file)
+}
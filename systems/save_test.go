@@ -0,0 +1,73 @@
+package systems
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readSave read a save file with the given contents
+func readSave(t *testing.T, data string) *SaveGame {
+	file := filepath.Join(t.TempDir(), "save.json")
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	save, err := ReadSave(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return save
+}
+
+func TestReadSaveBeforeEconomy(t *testing.T) {
+	save := readSave(t, `{"version": 1, "level": "levels/default.json"}`)
+	level, err := LoadLevel("levels/default.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(save.Nodes) != len(level.Resources) || len(save.Buildings) != len(level.Buildings) {
+		t.Errorf("save got %d nodes and %d buildings, the level has %d and %d",
+			len(save.Nodes), len(save.Buildings), len(level.Resources), len(level.Buildings))
+	}
+	for _, b := range save.Buildings {
+		if b.HP <= 0 {
+			t.Errorf("%s of team %d got %d hit points", b.Type, b.Team, b.HP)
+		}
+	}
+}
+
+func TestReadSaveWithoutEconomyLeft(t *testing.T) {
+	save := readSave(t, `{"version": 1, "level": "levels/default.json", "nodes": null, "buildings": null}`)
+	if len(save.Nodes) != 0 || len(save.Buildings) != 0 {
+		t.Errorf("save that ran out of nodes and buildings got %d nodes and %d buildings", len(save.Nodes), len(save.Buildings))
+	}
+}
+
+func TestReadSaveWithoutLevel(t *testing.T) {
+	save := readSave(t, `{"version": 1, "level": ""}`)
+	if len(save.Nodes) != 0 || len(save.Buildings) != 0 {
+		t.Errorf("save without a level got %d nodes and %d buildings", len(save.Nodes), len(save.Buildings))
+	}
+}
+
+func TestReadSaveBuildingHP(t *testing.T) {
+	save := readSave(t, `{"version": 2, "level": "levels/default.json",
+		"buildings": [{"type": "depot", "team": 1, "x": 2, "y": 2}, {"type": "depot", "team": 2, "x": 40, "y": 40, "hp": 7}]}`)
+	types, err := LoadBuildingTypes(BuildingTypesURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	depot, err := types.Lookup("depot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(save.Buildings) != 2 {
+		t.Fatalf("save has %d buildings, want 2", len(save.Buildings))
+	}
+	if hp := save.Buildings[0].HP; hp != depot.HP {
+		t.Errorf("building without hit points got %d, want the %d of its type", hp, depot.HP)
+	}
+	if hp := save.Buildings[1].HP; hp != 7 {
+		t.Errorf("building with 7 hit points got %d", hp)
+	}
+}
@@ -0,0 +1,190 @@
+package systems
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/EngoEngine/engo"
+)
+
+// Scenario a headless run of the simulation: a map, the units on it and the
+// orders they are given at set ticks
+type Scenario struct {
+	// Level url, its spawns are placed before Units. Without a level the map is
+	// an open grid of Rows by Cols tiles.
+	Level string `json:"level"`
+	Rows  int    `json:"rows"`
+	Cols  int    `json:"cols"`
+	// Blocked tiles of the pathing grid, as [x, y], on top of the level
+	Blocked [][2]int `json:"blocked"`
+	// UnitTypes url of the unit type definitions, UnitTypesURL by default
+	UnitTypes string `json:"unitTypes"`
+
+	Units  []ScenarioUnit  `json:"units"`
+	Orders []ScenarioOrder `json:"orders"`
+	Ticks  int             `json:"ticks"`
+}
+
+// ScenarioUnit unit placed at the start of a scenario. Units get their IDs in
+// the order they are placed, starting at 1 with the spawns of the level.
+type ScenarioUnit struct {
+	Type string  `json:"type"`
+	Team Team    `json:"team"`
+	X    float32 `json:"x"` // top left corner, as for SpawnUnitAtLocation
+	Y    float32 `json:"y"`
+}
+
+// ScenarioOrder command issued before the given tick is run
+type ScenarioOrder struct {
+	Tick      int        `json:"tick"`
+	Units     []UnitID   `json:"units"`
+	Kind      OrderKind  `json:"kind"`
+	X         float32    `json:"x"`
+	Y         float32    `json:"y"`
+	Enemy     UnitID     `json:"enemy"`
+	Building  BuildingID `json:"building"`
+	Queue     bool       `json:"queue"`
+	Formation Formation  `json:"formation"`
+	Type      string     `json:"type"`
+	Team      Team       `json:"team"`
+}
+
+// SimState state of the simulation after a tick, as dumped by headless runs
+type SimState struct {
+	Tick  int         `json:"tick"`
+	Units []UnitState `json:"units"`
+}
+
+// UnitState state of a single living unit
+type UnitState struct {
+	ID     UnitID      `json:"id"`
+	Type   string      `json:"type"`
+	Team   Team        `json:"team"`
+	X      float32     `json:"x"` // center
+	Y      float32     `json:"y"`
+	HP     int         `json:"hp"`
+	Moving bool        `json:"moving"`
+	Target UnitID      `json:"target,omitempty"`
+	Orders []OrderKind `json:"orders"`
+	Stuck  string      `json:"stuck,omitempty"` // why the unit gave up on where it was going
+}
+
+// LoadScenario read a scenario from a JSON file
+func LoadScenario(file string) (*Scenario, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read scenario %s: %v", file, err)
+	}
+	var sc Scenario
+	if err := json.Unmarshal(data, &sc); err != nil {
+		return nil, fmt.Errorf("scenario %s: %v", file, err)
+	}
+	return &sc, nil
+}
+
+// Run simulate the scenario without a window and return the state after its
+// last tick
+func (sc *Scenario) Run() (*SimState, error) {
+	us, err := sc.Start()
+	if err != nil {
+		return nil, err
+	}
+	defer us.Stop()
+
+	orders := append([]ScenarioOrder(nil), sc.Orders...)
+	sort.SliceStable(orders, func(i, j int) bool { return orders[i].Tick < orders[j].Tick })
+	for tick := 0; tick < sc.Ticks; tick++ {
+		for len(orders) > 0 && orders[0].Tick <= tick {
+			us.Issue(orders[0].command())
+			orders = orders[1:]
+		}
+		us.Tick()
+	}
+	return us.State(), nil
+}
+
+// Start set up the map and units of the scenario in a headless spawner, no
+// tick has run yet
+func (sc *Scenario) Start() (*UnitSpawner, error) {
+	url := sc.UnitTypes
+	if url == "" {
+		url = UnitTypesURL
+	}
+	types, err := LoadUnitTypes(url)
+	if err != nil {
+		return nil, err
+	}
+
+	var level *Level
+	if sc.Level != "" {
+		if level, err = LoadLevel(sc.Level); err != nil {
+			return nil, err
+		}
+	} else if sc.Rows > 0 && sc.Cols > 0 {
+		// An empty level with a tile per pathing tile
+		level = &Level{Width: sc.Rows, Height: sc.Cols, TileWidth: discreteStep, TileHeight: discreteStep}
+	}
+
+	us := &UnitSpawner{Level: level, Types: types, Headless: true}
+	us.New(nil)
+	for _, tile := range sc.Blocked {
+		us.ast.FillTile(Point{X: tile[0], Y: tile[1]}, -1)
+	}
+
+	var units []ScenarioUnit
+	if level != nil {
+		for _, spawn := range level.Spawns {
+			units = append(units, ScenarioUnit{Type: spawn.Unit, Team: spawn.Team, X: spawn.Position.X, Y: spawn.Position.Y})
+		}
+	}
+	for _, unit := range append(units, sc.Units...) {
+		if _, err := us.SpawnUnitAtLocation(unit.X, unit.Y, unit.Type, unit.Team); err != nil {
+			us.Stop()
+			return nil, err
+		}
+	}
+	return us, nil
+}
+
+// command the command the order issues
+func (o ScenarioOrder) command() Command {
+	return Command{
+		Units:     o.Units,
+		Kind:      o.Kind,
+		Target:    ToFixedPoint(engo.Point{X: o.X, Y: o.Y}),
+		Enemy:     o.Enemy,
+		Building:  o.Building,
+		Queue:     o.Queue,
+		Formation: o.Formation,
+		Type:      o.Type,
+		Team:      o.Team,
+	}
+}
+
+// State the state of the living units, by ID
+func (us *UnitSpawner) State() *SimState {
+	state := &SimState{Tick: us.tick, Units: []UnitState{}}
+	for _, unit := range us.AliveUnits {
+		center := unit.pos.Engo()
+		orders := make([]OrderKind, len(unit.orders))
+		for i, order := range unit.orders {
+			orders[i] = order.Kind
+		}
+		state.Units = append(state.Units, UnitState{
+			ID:     unit.id,
+			Type:   unit.kind.Name,
+			Team:   unit.team,
+			X:      center.X,
+			Y:      center.Y,
+			HP:     unit.hp,
+			Moving: unit.moving() || us.paths.Pending(unit),
+			Target: unit.target.UnitID(),
+			Orders: orders,
+			Stuck:  unit.stuck.String(),
+		})
+	}
+	sort.Slice(state.Units, func(i, j int) bool { return state.Units[i].ID < state.Units[j].ID })
+	return state
+}
@@ -0,0 +1,67 @@
+package systems
+
+import (
+	"math"
+	"testing"
+)
+
+// wallScenario a fish on an open grid of 40 by 40 tiles, left of a wall
+// across the grid with a gap near the bottom, ordered to a point right of
+// the wall
+func wallScenario() *Scenario {
+	sc := &Scenario{
+		Rows:  40,
+		Cols:  40,
+		Units: []ScenarioUnit{{Type: "fish", Team: 1, X: 8, Y: 8}},
+		Orders: []ScenarioOrder{
+			{Tick: 0, Units: []UnitID{1}, Kind: OrderMove, X: 280, Y: 40},
+		},
+		Ticks: 200,
+	}
+	for y := 0; y < 40; y++ {
+		if y != 35 {
+			sc.Blocked = append(sc.Blocked, [2]int{20, y})
+		}
+	}
+	return sc
+}
+
+func TestScenarioMoveAroundWall(t *testing.T) {
+	state, err := wallScenario().Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Units) != 1 {
+		t.Fatalf("%d units left, want 1", len(state.Units))
+	}
+	unit := state.Units[0]
+	if unit.Moving || unit.Stuck != "" {
+		t.Fatalf("unit still moving (%v) or stuck (%q) after %d ticks", unit.Moving, unit.Stuck, state.Tick)
+	}
+	if dist := math.Hypot(float64(unit.X-280), float64(unit.Y-40)); dist > discreteStep {
+		t.Errorf("unit ended at (%v, %v), %.1f pixels from its target", unit.X, unit.Y, dist)
+	}
+}
+
+func TestScenarioUnreachableTarget(t *testing.T) {
+	sc := wallScenario()
+	// Wall in the tile of the target
+	for x := 34; x <= 36; x++ {
+		for y := 4; y <= 6; y++ {
+			if x != 35 || y != 5 {
+				sc.Blocked = append(sc.Blocked, [2]int{x, y})
+			}
+		}
+	}
+	state, err := sc.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit := state.Units[0]
+	if unit.Moving {
+		t.Error("unit is still moving towards a target it can not reach")
+	}
+	if unit.Stuck != StuckNoPath.String() {
+		t.Errorf("unit reports stuck reason %q, want %q", unit.Stuck, StuckNoPath.String())
+	}
+}
@@ -0,0 +1,187 @@
+package systems
+
+import (
+	"fmt"
+
+	"github.com/EngoEngine/ecs"
+	"github.com/EngoEngine/engo"
+)
+
+// Selection settings
+const (
+	// Control groups are numbered 1 to 9, index 0 is unused
+	controlGroups = 10
+	// Recalling the same group twice within this many seconds centers the
+	// camera on it
+	doubleTapTime = 0.3
+)
+
+// Names of the modifier buttons
+const (
+	shiftButton   = "Shift"
+	controlButton = "Control"
+)
+
+// groupKeys the keys of control groups 1 to 9
+var groupKeys = []engo.Key{
+	engo.KeyOne, engo.KeyTwo, engo.KeyThree, engo.KeyFour, engo.KeyFive,
+	engo.KeySix, engo.KeySeven, engo.KeyEight, engo.KeyNine,
+}
+
+// groupButton name of the button of a control group
+func groupButton(group int) string {
+	return fmt.Sprintf("ControlGroup%d", group)
+}
+
+// registerSelectionButtons register the modifiers and control group keys
+func registerSelectionButtons() {
+	engo.Input.RegisterButton(shiftButton, engo.KeyLeftShift, engo.KeyRightShift)
+	engo.Input.RegisterButton(controlButton, engo.KeyLeftControl, engo.KeyRightControl)
+	for i, key := range groupKeys {
+		engo.Input.RegisterButton(groupButton(i+1), key)
+	}
+}
+
+func shiftDown() bool {
+	return engo.Input.Button(shiftButton).Down()
+}
+
+func controlDown() bool {
+	return engo.Input.Button(controlButton).Down()
+}
+
+// selectUnits replace the selection, the selected building included
+func (s *MouseFollower) selectUnits(units []*BasicUnit) {
+	s.selectBuilding(nil)
+	for _, unit := range s.selected {
+		unit.Deselect()
+	}
+	for _, unit := range units {
+		unit.Select()
+	}
+	s.selected = units
+}
+
+// addUnits add units to the selection, in place of the selected building
+func (s *MouseFollower) addUnits(units []*BasicUnit) {
+	if len(units) > 0 {
+		s.selectBuilding(nil)
+	}
+	for _, unit := range units {
+		if !containsUnit(s.selected, unit) {
+			unit.Select()
+			s.selected = append(s.selected, unit)
+		}
+	}
+}
+
+// updateControlGroups Control and a number assigns the selection to a group,
+// the number alone recalls it
+func (s *MouseFollower) updateControlGroups() {
+	for group := 1; group < controlGroups; group++ {
+		if !engo.Input.Button(groupButton(group)).JustPressed() {
+			continue
+		}
+		if controlDown() {
+			s.groups[group] = append([]*BasicUnit(nil), s.selected...)
+			continue
+		}
+		s.recallGroup(group)
+	}
+}
+
+// recallGroup select a control group, the second recall in quick succession
+// centers the camera on the group
+func (s *MouseFollower) recallGroup(group int) {
+	units := s.groups[group]
+	if len(units) == 0 {
+		return
+	}
+	s.selectUnits(append([]*BasicUnit(nil), units...))
+
+	if group == s.lastGroup && s.clock-s.lastTap <= doubleTapTime {
+		CenterCamera(groupCenter(units))
+	}
+	s.lastGroup = group
+	s.lastTap = s.clock
+}
+
+// visibleOfType the units of the player of the given type that are on screen
+func (s *MouseFollower) visibleOfType(sys *UnitSpawner, kind *UnitType) []*BasicUnit {
+	view := CameraView(s.camera)
+	var units []*BasicUnit
+	for _, unit := range sys.AliveUnits {
+		if unit.team != sys.Player || unit.kind != kind {
+			continue
+		}
+		c := unit.SpaceComponent.Center()
+		if c.X >= view.Min.X && c.X <= view.Max.X && c.Y >= view.Min.Y && c.Y <= view.Max.Y {
+			units = append(units, unit)
+		}
+	}
+	return units
+}
+
+// groupCenter the average center of the units
+func groupCenter(units []*BasicUnit) engo.Point {
+	var center engo.Point
+	for _, unit := range units {
+		c := unit.SpaceComponent.Center()
+		center.X += c.X
+		center.Y += c.Y
+	}
+	center.X /= float32(len(units))
+	center.Y /= float32(len(units))
+	return center
+}
+
+func containsUnit(units []*BasicUnit, unit *BasicUnit) bool {
+	for _, u := range units {
+		if u == unit {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutUnit remove the unit of an entity from a slice of units, in place
+func withoutUnit(units []*BasicUnit, basic ecs.BasicEntity) []*BasicUnit {
+	for i, unit := range units {
+		if unit.ID() == basic.ID() {
+			return append(units[:i], units[i+1:]...)
+		}
+	}
+	return units
+}
+
+// saveGroups the control groups by unit ID, for a save
+func (s *MouseFollower) saveGroups() [][]UnitID {
+	groups := make([][]UnitID, len(s.groups))
+	for i, group := range s.groups {
+		groups[i] = unitIDs(group)
+	}
+	return groups
+}
+
+// restoreSelection take the selection and control groups of a loaded game,
+// the units of the old game are gone
+func (s *MouseFollower) restoreSelection(sys *UnitSpawner, groups [][]UnitID) {
+	s.selected, s.dragBase = nil, nil
+	for _, unit := range sys.AliveUnits {
+		if unit.selected {
+			s.selected = append(s.selected, unit)
+		}
+	}
+	for i := range s.groups {
+		s.groups[i] = nil
+		if i >= len(groups) {
+			continue
+		}
+		for _, id := range groups[i] {
+			if unit := sys.Unit(id); unit != nil {
+				s.groups[i] = append(s.groups[i], unit)
+			}
+		}
+	}
+	s.arm(OrderMove)
+}
@@ -0,0 +1,201 @@
+package systems
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+
+	"github.com/EngoEngine/engo"
+)
+
+// Simulation timing. The simulation advances in ticks of a fixed length,
+// whatever the frame rate, so that it plays out the same everywhere.
+const (
+	// TickRate ticks per second
+	TickRate = 20
+	// TickDuration seconds per tick
+	TickDuration = 1.0 / TickRate
+	// Ticks run at most per Update, a slow frame drops the time it is behind
+	// beyond that instead of running ever more ticks to catch up
+	maxTicksPerUpdate = 5
+)
+
+// UnitID identifies a unit in commands. IDs are handed out in spawn order, so
+// a unit has the same ID in every run of the same game.
+type UnitID uint32
+
+// Command order for a group of units or a building. Commands are the only
+// input of the simulation: they are issued, then carried out at the start of
+// the next tick.
+type Command struct {
+	Units     []UnitID   `json:"units"`
+	Kind      OrderKind  `json:"kind"`
+	Target    FixedPoint `json:"target"`
+	Enemy     UnitID     `json:"enemy,omitempty"`    // unit to attack, 0 for none
+	Building  BuildingID `json:"building,omitempty"` // building to attack or that is ordered, 0 for none
+	Queue     bool       `json:"queue,omitempty"`    // carry out after the orders the units already have
+	Formation Formation  `json:"formation"`
+	Type      string     `json:"type,omitempty"` // unit type to train or building type to build
+	Team      Team       `json:"team,omitempty"` // team that pays for and owns what is trained or built
+}
+
+// CommandSource hands the simulation the commands of every tick from outside
+// the game, such as a replay
+type CommandSource interface {
+	// Commands of the tick, false while they are not known yet, which holds
+	// the simulation back
+	Commands(tick int) ([]Command, bool)
+}
+
+// CommandSink a CommandSource that the commands issued in the game have to
+// go through, such as a network game that shares them with the other players
+// before they are carried out
+type CommandSink interface {
+	CommandSource
+	// Send a command issued by the local player
+	Send(cmd Command)
+}
+
+// Issue queue a command for the next tick, or hand it to the Source if that
+// is a CommandSink
+func (us *UnitSpawner) Issue(cmd Command) {
+	if sink, ok := us.Source.(CommandSink); ok {
+		sink.Send(cmd)
+		return
+	}
+	us.commands = append(us.commands, cmd)
+}
+
+// apply carry out a command, units that died since it was issued are left out.
+// A command of a team, such as one from a network game, only orders the units
+// of that team.
+func (us *UnitSpawner) apply(cmd Command) {
+	switch cmd.Kind {
+	case OrderBuild:
+		us.build(cmd)
+		return
+	case OrderTrain:
+		us.train(cmd)
+		return
+	case OrderRally:
+		us.rally(cmd)
+		return
+	}
+	units := make([]*BasicUnit, 0, len(cmd.Units))
+	for _, id := range cmd.Units {
+		if unit, ok := us.units[id]; ok && (cmd.Team == NeutralTeam || unit.team == cmd.Team) {
+			units = append(units, unit)
+		}
+	}
+	us.command(units, us.units[cmd.Enemy], cmd)
+}
+
+// unitIDs the IDs of the units
+func unitIDs(units []*BasicUnit) []UnitID {
+	ids := make([]UnitID, len(units))
+	for i, unit := range units {
+		ids[i] = unit.id
+	}
+	return ids
+}
+
+// Unit the living unit with the given ID, or nil
+func (us *UnitSpawner) Unit(id UnitID) *BasicUnit {
+	return us.units[id]
+}
+
+// UnitID the ID of the unit in commands, 0 for no unit
+func (unit *BasicUnit) UnitID() UnitID {
+	if unit == nil {
+		return 0
+	}
+	return unit.id
+}
+
+// Ticks number of ticks the simulation ran
+func (us *UnitSpawner) Ticks() int {
+	return us.tick
+}
+
+// Tick advance the simulation by one tick. Paths searched during a tick are
+// handed to the units at the start of the next one, however long the search
+// took, so the outcome does not depend on timing. Returns false, without
+// doing anything, when the Source does not have the commands of the tick yet.
+func (us *UnitSpawner) Tick() bool {
+	commands := us.commands
+	if us.Source != nil {
+		cmds, ok := us.Source.Commands(us.tick)
+		if !ok {
+			return false
+		}
+		commands = append(commands, cmds...)
+	}
+	us.commands = nil
+	us.ticking = true
+	defer func() { us.ticking = false }()
+
+	us.paths.Deliver()
+	for _, unit := range us.AliveUnits {
+		unit.prevPos = unit.pos
+	}
+	for _, cmd := range commands {
+		if us.Record != nil {
+			us.Record.add(us.tick, cmd)
+		}
+		us.apply(cmd)
+	}
+
+	us.refreshFlows()
+	us.move()
+	us.steer()
+	us.fight()
+	us.harvest()
+	us.produce()
+	for _, unit := range us.AliveUnits {
+		us.runOrders(unit)
+	}
+	us.invalidatePaths()
+	us.see()
+	us.paths.Flush()
+	us.tick++
+	if us.Record != nil {
+		us.Record.Ticks = us.tick
+	}
+	return true
+}
+
+// Checksum a hash of the state of the simulation: the tick, the position, hit
+// points, target, orders and load of every unit, the hit points and production
+// of every building and the stock of every team. Games that are in step have
+// the same checksum at the same tick.
+func (us *UnitSpawner) Checksum() uint32 {
+	h := fnv.New32a()
+	values := []int64{int64(us.tick), int64(len(us.AliveUnits))}
+	for _, unit := range us.AliveUnits {
+		values = append(values, int64(unit.id), int64(unit.pos.X), int64(unit.pos.Y), int64(unit.hp),
+			int64(unit.target.UnitID()), int64(len(unit.orders)), int64(unit.carrying))
+	}
+	for _, b := range us.buildings {
+		values = append(values, int64(b.id), int64(b.hp), int64(len(b.queue)), int64(b.progress))
+	}
+	for _, team := range us.stockTeams() {
+		values = append(values, int64(team), int64(us.stock[team]))
+	}
+	binary.Write(h, binary.LittleEndian, values)
+	return h.Sum32()
+}
+
+// interpolate place the units in between their last two tick positions, alpha
+// being how far the clock is into the next tick
+func (us *UnitSpawner) interpolate(alpha float32) {
+	for _, unit := range us.AliveUnits {
+		from, to := unit.prevPos.Engo(), unit.pos.Engo()
+		unit.place(engo.Point{X: from.X + (to.X-from.X)*alpha, Y: from.Y + (to.Y-from.Y)*alpha})
+	}
+}
+
+// place draw the unit, its shadow and its health bar around center
+func (unit *BasicUnit) place(center engo.Point) {
+	unit.SpaceComponent.Position = engo.Point{X: center.X - unit.SpaceComponent.Width/2, Y: center.Y - unit.SpaceComponent.Height/2}
+	unit.shadow.SpaceComponent.Position = unit.SpaceComponent.Position
+	unit.healthBar.SpaceComponent.Position = engo.Point{X: unit.SpaceComponent.Position.X, Y: unit.SpaceComponent.Position.Y - healthBarHeight}
+}
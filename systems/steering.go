@@ -0,0 +1,179 @@
+package systems
+
+// Separation steering settings
+const (
+	// Size of the buckets units are sorted into to find their neighbours,
+	// must be at least the largest separation distance
+	steeringBucket = 64
+	// A moving unit that bumps into an idle unit with the same goal, this close
+	// to that goal, stops there so groups spread out around their target
+	arrivalRange = 96
+	// How much of the overlap between two units is resolved per tick
+	separationStrength = FixedOne / 2
+)
+
+// spreadDirections directions units on the exact same spot are split along
+var spreadDirections = [8]FixedPoint{
+	{FixedOne, 0}, {diagonal, diagonal}, {0, FixedOne}, {-diagonal, diagonal},
+	{-FixedOne, 0}, {-diagonal, -diagonal}, {0, -FixedOne}, {diagonal, -diagonal},
+}
+
+// diagonal both coordinates of a diagonal unit vector, 1/sqrt(2)
+const diagonal Fixed = 46341
+
+// radius how close the center of another unit may come before they push each
+// other apart
+func (unit *BasicUnit) radius() Fixed {
+	return unit.kind.radius
+}
+
+// moving check if the unit is following a path or flow field
+func (unit *BasicUnit) moving() bool {
+	return unit.path != nil || unit.flow != nil
+}
+
+// stop drop the path or flow field the unit is following
+func (unit *BasicUnit) stop() {
+	unit.path = nil
+	unit.flow = nil
+}
+
+// steer separate units that overlap, run after the units moved along their
+// paths. Units on the move push each other apart evenly and so do idle ones.
+// An idle unit in the way of a moving one steps aside, out of its heading,
+// while the moving unit keeps going.
+func (us *UnitSpawner) steer() {
+	units := us.AliveUnits
+	pushes := make([]FixedPoint, len(units))
+
+	buckets := make(map[Point][]int)
+	for i, unit := range units {
+		b := bucketOf(unit.pos)
+		buckets[b] = append(buckets[b], i)
+	}
+
+	for i, a := range units {
+		b := bucketOf(a.pos)
+		for dx := -1; dx <= 1; dx++ {
+			for dy := -1; dy <= 1; dy++ {
+				for _, j := range buckets[Point{b.X + dx, b.Y + dy}] {
+					if j <= i {
+						continue
+					}
+					us.separatePair(a, units[j], &pushes[i], &pushes[j], i, j)
+				}
+			}
+		}
+	}
+
+	for i, unit := range units {
+		us.push(unit, pushes[i])
+	}
+}
+
+// separatePair add the pushes that separate two overlapping units
+func (us *UnitSpawner) separatePair(a, b *BasicUnit, pushA, pushB *FixedPoint, i, j int) {
+	d := a.pos.Sub(b.pos)
+	dist := d.Len()
+	overlap := a.radius() + b.radius() - dist
+	if overlap <= 0 {
+		return
+	}
+
+	// Direction from b to a, units on the exact same spot are split along a
+	// fixed direction so the result does not depend on anything but their order
+	var dir FixedPoint
+	if dist > 0 {
+		dir = FixedPoint{d.X.Div(dist), d.Y.Div(dist)}
+	} else {
+		dir = spreadDirections[(i*7+j)%len(spreadDirections)]
+	}
+
+	switch {
+	case a.moving() && !b.moving():
+		if us.arrived(a, b) {
+			return
+		}
+		*pushB = addScaled(*pushB, yieldDirection(FixedPoint{-dir.X, -dir.Y}, a.heading), overlap)
+	case b.moving() && !a.moving():
+		if us.arrived(b, a) {
+			return
+		}
+		*pushA = addScaled(*pushA, yieldDirection(dir, b.heading), overlap)
+	default:
+		*pushA = addScaled(*pushA, dir, overlap/2)
+		*pushB = addScaled(*pushB, dir, -overlap/2)
+	}
+}
+
+// arrived stop a moving unit that bumps into an idle one that went to the same
+// goal, when they are both close to that goal
+func (us *UnitSpawner) arrived(mover, idle *BasicUnit) bool {
+	if mover.goal != idle.goal {
+		return false
+	}
+	if mover.pos.Dist(PathingToFixed(mover.goal)) > FixedFromInt(arrivalRange) {
+		return false
+	}
+	mover.stop()
+	return true
+}
+
+// push move a unit by its separation push, no further than its speed and never
+// onto impassable tiles
+func (us *UnitSpawner) push(unit *BasicUnit, push FixedPoint) {
+	length := push.Len().Mul(separationStrength)
+	if length == 0 {
+		return
+	}
+	scale := separationStrength
+	if length > unit.speed {
+		scale = scale.Mul(unit.speed.Div(length))
+	}
+	push = push.Scale(scale)
+
+	grid, ok := us.ast.(Grid)
+	if !ok {
+		unit.pos = unit.pos.Add(push)
+		return
+	}
+	// Try the full push, then slide along either axis
+	for _, p := range []FixedPoint{push, {X: push.X}, {Y: push.Y}} {
+		to := unit.pos.Add(p)
+		if to.X >= 0 && to.Y >= 0 && grid.Weight(FixedToPathing(to)) != -1 {
+			unit.pos = to
+			return
+		}
+	}
+}
+
+// yieldDirection the direction an idle unit steps in to get out of the way of
+// a unit moving along heading. away points from the moving unit to the idle one.
+func yieldDirection(away, heading FixedPoint) FixedPoint {
+	if heading.X == 0 && heading.Y == 0 {
+		return away
+	}
+	// Sideways from the heading, on the side the idle unit is already on
+	side := FixedPoint{-heading.Y, heading.X}
+	if side.Dot(away) < 0 {
+		side = FixedPoint{heading.Y, -heading.X}
+	}
+	return FixedPoint{side.X + away.X/2, side.Y + away.Y/2}.Normalize()
+}
+
+func addScaled(p, dir FixedPoint, scale Fixed) FixedPoint {
+	return p.Add(dir.Scale(scale))
+}
+
+func bucketOf(p FixedPoint) Point {
+	return Point{X: floorDiv(p.X.Int(), steeringBucket), Y: floorDiv(p.Y.Int(), steeringBucket)}
+}
+
+// floorDiv divide, rounding towards negative infinity
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
@@ -0,0 +1,87 @@
+package systems
+
+import (
+	"image/color"
+	"sort"
+)
+
+// Team player or faction that owns units
+type Team int
+
+// NeutralTeam owns units that belong to no player, they are hostile to nobody
+const NeutralTeam Team = 0
+
+// teamColors shadow colour of the units of each team, neutral first
+var teamColors = []color.RGBA{
+	{128, 128, 128, 255}, // neutral
+	{40, 90, 220, 255},
+	{210, 40, 40, 255},
+	{230, 190, 30, 255},
+	{140, 60, 190, 255},
+	{30, 170, 90, 255},
+	{230, 120, 30, 255},
+}
+
+// Color of the team, teams beyond the palette reuse its colours
+func (t Team) Color() color.RGBA {
+	if t <= NeutralTeam {
+		return teamColors[0]
+	}
+	return teamColors[1+(int(t)-1)%(len(teamColors)-1)]
+}
+
+// Diplomacy hostility rules between teams. Teams are hostile to each other
+// unless they are allied, and nobody is hostile to the neutral team. The zero
+// value is ready to use.
+type Diplomacy struct {
+	allies map[[2]Team]bool
+}
+
+// teamPair key for a pair of teams, independent of their order
+func teamPair(a, b Team) [2]Team {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]Team{a, b}
+}
+
+// Ally make two teams friends
+func (d *Diplomacy) Ally(a, b Team) {
+	if d.allies == nil {
+		d.allies = make(map[[2]Team]bool)
+	}
+	d.allies[teamPair(a, b)] = true
+}
+
+// Break end an alliance between two teams
+func (d *Diplomacy) Break(a, b Team) {
+	delete(d.allies, teamPair(a, b))
+}
+
+// Alliances the pairs of allied teams, sorted
+func (d *Diplomacy) Alliances() [][2]Team {
+	pairs := make([][2]Team, 0, len(d.allies))
+	for pair := range d.allies {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+	return pairs
+}
+
+// Allied check if two teams are on the same side, every team is its own ally
+func (d *Diplomacy) Allied(a, b Team) bool {
+	return a == b || d.allies[teamPair(a, b)]
+}
+
+// Hostile check if units of the two teams fight each other
+func (d *Diplomacy) Hostile(a, b Team) bool {
+	if a == NeutralTeam || b == NeutralTeam {
+		return false
+	}
+	return !d.Allied(a, b)
+}
@@ -1,18 +1,14 @@
 package systems
 
 import (
-	"fmt"
 	"image/color"
-	"math"
+	"log"
 
 	"github.com/EngoEngine/ecs"
 	"github.com/EngoEngine/engo"
 	"github.com/EngoEngine/engo/common"
 )
 
-// Spritesheet contains art for units
-var Spritesheet *common.Spritesheet
-
 // Unit interface which defines what a unit can do
 type Unit interface {
 	// exported
@@ -20,8 +16,9 @@ type Unit interface {
 	Select()
 	Move(AStar, AStarConfig, engo.Point)
 	Register(*UnitSpawner)
+	Gather(*UnitSpawner, *ResourceNode)
 	// internal
-	step(float32, float32)
+	step(FixedPoint)
 }
 
 // BasicUnit Common unit fields
@@ -32,21 +29,47 @@ type BasicUnit struct {
 	common.MouseComponent
 	common.AnimationComponent
 	common.CollisionComponent
-	position engo.Point
-	selected bool
-	speed    float32
-	shadow   Shadow
-	path     *PathPoint
-}
+	id        UnitID
+	kind      *UnitType
+	team      Team
+	selected  bool
+	hidden    bool // out of sight of the player
+	shadow    Shadow
+	healthBar HealthBar
 
-// Fish First specific unit type
-type Fish struct {
-	*BasicUnit
-}
+	// Movement, in simulation units. The components above only draw the unit
+	// in between prevPos and pos.
+	pos     FixedPoint // center of the unit
+	prevPos FixedPoint // center at the end of the previous tick
+	speed   Fixed      // pixels per tick
+	pace    Fixed      // speed of the group the unit moves with, 0 when alone
+	path    *PathPoint
+	flow    *FlowField
+	goal    Point      // tile the unit was last ordered to
+	heading FixedPoint // direction of the last step
+
+	// Blocked paths
+	pathVersion uint64      // version of the grid the path was last checked against
+	blockedFor  int         // ticks the unit has waited in front of a blocked tile
+	repaths     int         // paths searched since the unit last reached a waypoint
+	stuck       StuckReason // why the unit last gave up, if it did
 
-// Blob Second unit type
-type Blob struct {
-	*BasicUnit
+	// Combat
+	hp           int
+	cooldownLeft int        // ticks until the unit can attack again
+	target       *BasicUnit // unit to attack, if any
+	siege        *Building  // building to attack when there is no target
+	chaseTile    Point      // where the target was when the unit set out after it
+	attackMove   bool       // fight enemies met on the way to attackGoal
+	attackGoal   FixedPoint
+	hold         bool // only fight enemies in range, never chase
+
+	orders []*Order // order queue, the current order first
+
+	// Gathering
+	carrying    int // resources the unit brings to a drop-off
+	harvestLeft int // ticks until the unit has harvested a load, 0 when not harvesting
+	gatherTries int // walks in a row that did not end where the unit was going
 }
 
 // Shadow render unit shadow
@@ -56,19 +79,104 @@ type Shadow struct {
 	common.SpaceComponent
 }
 
+// Size of the pathing grid when there is no level
+const (
+	defaultGridRows = 300
+	defaultGridCols = 300
+)
+
 // UnitSpawner takes care of unit spawning
 type UnitSpawner struct {
-	world      *ecs.World
-	AliveUnits []*BasicUnit // slice of pointers to all units
-	ast        AStar
-	p2p        AStarConfig
+	// Level the units are in, sets the pathing grid. Optional.
+	Level *Level
+	// Types the unit types that can be spawned, their images have to be
+	// loaded. Read from UnitTypesURL when left empty.
+	Types *UnitTypes
+	// Player the team of the local player, the only units it can select
+	Player Team
+	// Diplomacy which teams fight each other
+	Diplomacy Diplomacy
+	// Formation the groups of the player take when they are ordered to move
+	// together
+	Formation Formation
+	// Headless run the simulation without a world: units get no textures and
+	// are not drawn, and ticks only advance when Tick is called
+	Headless bool
+	// Speed of the simulation clock, 2 runs twice as fast as real time. 0 is
+	// real time.
+	Speed float32
+	// Paused stops the clock, the units stay where they are drawn
+	Paused bool
+	// Source commands from outside the game for every tick, optional
+	Source CommandSource
+	// Record replay that the setup and every command carried out are
+	// recorded into, optional
+	Record *Replay
+	// Fog hide what the player and its allies do not see
+	Fog bool
+	// BuildingTypes the building types that can be placed. Read from
+	// BuildingTypesURL when left empty.
+	BuildingTypes *BuildingTypes
+	// Neighbourhood of the pathing grid, FourWay unless set
+	Neighbourhood Neighbourhood
+	// Corners the rule for cutting corners on an EightWay grid
+	Corners CornerRule
+
+	world         *ecs.World
+	AliveUnits    []*BasicUnit // slice of pointers to all units
+	units         map[UnitID]*BasicUnit
+	lastID        UnitID
+	commands      []Command // issued for the next tick
+	tick          int
+	ticking       bool    // in the middle of a tick
+	lag           float32 // seconds the simulation is behind the clock
+	waypointMarks []*waypointMark
+	ast           AStar
+	p2p           AStarConfig
+	paths         *PathService
+	vision        map[Team]*VisionGrid
+	blockers      sightBlockers
+	fog           *fogOverlay
+	nodes         []*ResourceNode
+	buildings     []*Building
+	lastBuilding  BuildingID
+	stock         map[Team]int // resources gathered by every team
 }
 
 // Remove is called whenever an Entity is removed from the scene, and thus from this system
-func (*UnitSpawner) Remove(ecs.BasicEntity) {}
+func (us *UnitSpawner) Remove(basic ecs.BasicEntity) {
+	index := -1
+	for i, unit := range us.AliveUnits {
+		if unit.ID() == basic.ID() {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return
+	}
+	removed := us.AliveUnits[index]
+	us.AliveUnits = append(us.AliveUnits[:index], us.AliveUnits[index+1:]...)
+	delete(us.units, removed.id)
 
-// Add a unit to the system
+	us.paths.Cancel(removed)
+	for _, unit := range us.AliveUnits {
+		if unit.target == removed {
+			us.loseTarget(unit)
+		}
+	}
+}
+
+// Add a unit to the system, giving it the next unit ID unless it has one
 func (us *UnitSpawner) Add(u *BasicUnit) {
+	if us.units == nil {
+		us.units = make(map[UnitID]*BasicUnit)
+	}
+	if u.id == 0 {
+		us.lastID++
+		u.id = us.lastID
+	}
+	us.units[u.id] = u
 	us.AliveUnits = append(us.AliveUnits, u)
 }
 
@@ -76,114 +184,273 @@ func (us *UnitSpawner) Add(u *BasicUnit) {
 func (us *UnitSpawner) New(w *ecs.World) {
 	us.world = w
 
-	// Visuals
-	Spritesheet = common.NewSpritesheetFromFile("textures/art.png", 8, 8)
+	// Unit types
+	if us.Types == nil {
+		types, err := LoadUnitTypes(UnitTypesURL)
+		if err != nil {
+			log.Println(err)
+			types = &UnitTypes{}
+		} else if !us.Headless {
+			if err := engo.Files.Load(types.Images()...); err != nil {
+				log.Println(err)
+			}
+		}
+		us.Types = types
+	}
+	if us.BuildingTypes == nil {
+		types, err := LoadBuildingTypes(BuildingTypesURL)
+		if err != nil {
+			log.Println(err)
+			types = &BuildingTypes{}
+		}
+		us.BuildingTypes = types
+	}
+
+	us.stock = make(map[Team]int)
+	us.initPathing()
+	if us.Fog && !us.Headless {
+		us.newFog()
+	}
+
+	if !us.Headless {
+		log.Println("UnitSpawner was added to the Scene")
+	}
+}
+
+// initPathing create the pathing grid of the level and the path service, and
+// place the resource nodes and buildings of the level
+func (us *UnitSpawner) initPathing() {
+	us.newGrid()
+	if us.Level != nil {
+		us.Level.FillGrid(us.ast)
+	}
+	us.placeLevelEconomy()
+}
 
-	// Pathing
-	us.ast = NewAStar(300, 300) // algo
-	us.p2p = NewPointToPoint()  // config
+// newGrid create an empty pathing grid the size of the level, the path
+// service searching it and unexplored vision for every team
+func (us *UnitSpawner) newGrid() {
+	rows, cols := defaultGridRows, defaultGridCols
+	if us.Level != nil {
+		rows, cols = us.Level.GridSize()
+	}
+	var grid *gridStruct
+	if us.Neighbourhood == EightWay {
+		grid = NewAStarEightWay(rows, cols, us.Corners).(*gridStruct)
+	} else {
+		grid = NewAStar(rows, cols).(*gridStruct)
+	}
+	us.ast = NewHierarchicalAStar(grid, DefaultClusterSize) // algo
+	us.p2p = NewPointToPointSmooth(grid.heuristic())        // config
+	us.paths = NewPathService(us.ast, us.p2p, pathWorkers)
+	us.vision = make(map[Team]*VisionGrid)
+	if us.fog != nil {
+		us.drawFog(true)
+	}
+}
 
-	fmt.Println("UnitSpawner was added to the Scene")
+// Reset remove every unit and start over at tick 0, on a fresh pathing grid
+func (us *UnitSpawner) Reset() {
+	us.clear()
+	us.initPathing()
+}
+
+// clear remove every unit, node and building, stop the path service and go
+// back to tick 0
+func (us *UnitSpawner) clear() {
+	for len(us.AliveUnits) > 0 {
+		us.kill(us.AliveUnits[0])
+	}
+	us.clearEconomy()
+	us.paths.Stop()
+	us.lastID = 0
+	us.commands = nil
+	us.tick = 0
+	us.lag = 0
+}
 
+// Stop the path workers of a spawner that is no longer used
+func (us *UnitSpawner) Stop() {
+	us.paths.Stop()
 }
 
-// setUnitParameters assign the (texture, animation, speed) parameters to the provided unit
-func (us *UnitSpawner) setUnitParameters(unit *BasicUnit, texture common.Drawable, anim *common.Animation, speed float32) {
+// setUnitParameters assign the (texture, animation, speed, combat) parameters of its type to the provided unit
+func (us *UnitSpawner) setUnitParameters(unit *BasicUnit, t *UnitType) error {
+	unit.kind = t
+	unit.speed = t.speed
+	unit.hp = t.HP
+	if us.Headless {
+		return nil
+	}
+
+	sheet, err := t.spritesheet()
+	if err != nil {
+		return err
+	}
+	texture := sheet.Cell(t.Cell)
+
 	unit.RenderComponent = common.RenderComponent{
 		Drawable: texture,
-		Scale:    engo.Point{X: 8, Y: 8},
+		Scale:    engo.Point{X: t.Scale, Y: t.Scale},
 	}
 	unit.SpaceComponent = common.SpaceComponent{
-		Position: engo.Point{X: unit.position.X, Y: unit.position.Y},
-		Width:    texture.Width() * unit.RenderComponent.Scale.X,
-		Height:   texture.Height() * unit.RenderComponent.Scale.Y,
+		Width:  texture.Width() * unit.RenderComponent.Scale.X,
+		Height: texture.Height() * unit.RenderComponent.Scale.Y,
 	}
 
 	unit.shadow = Shadow{BasicEntity: ecs.NewBasic()}
 	unit.shadow.SpaceComponent = common.SpaceComponent{
-		Position: engo.Point{X: unit.position.X, Y: unit.position.Y},
-		Width:    texture.Width() * unit.RenderComponent.Scale.X,
-		Height:   texture.Height() * unit.RenderComponent.Scale.Y,
+		Width:  texture.Width() * unit.RenderComponent.Scale.X,
+		Height: texture.Height() * unit.RenderComponent.Scale.Y,
 	}
-	unit.shadow.RenderComponent = common.RenderComponent{Drawable: common.Circle{}, Color: color.RGBA{0, 0, 0, 255}}
+	unit.shadow.RenderComponent = common.RenderComponent{Drawable: common.Circle{}, Color: unit.team.Color()}
 
-	unit.AnimationComponent = common.NewAnimationComponent(Spritesheet.Drawables(), 0.5)
-	unit.AnimationComponent.AddDefaultAnimation(anim)
-	unit.speed = speed
+	unit.AnimationComponent = common.NewAnimationComponent(sheet.Drawables(), t.AnimationRate)
+	for i, anim := range t.animations() {
+		if i == 0 {
+			unit.AnimationComponent.AddDefaultAnimation(anim)
+		} else {
+			unit.AnimationComponent.AddAnimation(anim)
+		}
+	}
 	unit.CollisionComponent = common.CollisionComponent{Main: 1, Group: 1}
+
+	unit.newHealthBar()
+	unit.place(unit.pos.Engo())
+	return nil
 }
 
-// Create unit object based on unit type
-func (us *UnitSpawner) giveUnitParameters(unit *BasicUnit, unitID int) Unit {
-	var texture common.Drawable
-	var idle *common.Animation
-	var speed float32
-	if unitID == 0 {
-		texture = Spritesheet.Cell(7)
-		idle = &common.Animation{Name: "idle", Frames: []int{7, 8}}
-		speed = 4
-		us.setUnitParameters(unit, texture, idle, speed)
-		return &Fish{unit}
-
-	} else if unitID == 1 {
-		texture = Spritesheet.Cell(5)
-		idle = &common.Animation{Name: "idle", Frames: []int{5, 6}}
-		speed = 2
-		us.setUnitParameters(unit, texture, idle, speed)
-		return &Blob{unit}
-	} else {
-		return nil
+// NewUnit create a new unit entity of the named type, with its top left
+// corner at the given position
+func (us *UnitSpawner) newUnit(posx float32, posy float32, unitType string, team Team) (*BasicUnit, error) {
+	t, err := us.Types.Lookup(unitType)
+	if err != nil {
+		return nil, err
 	}
+	// Create empty unit entity
+	unit := &BasicUnit{BasicEntity: ecs.NewBasic()}
+	unit.pos = ToFixedPoint(engo.Point{X: posx, Y: posy}).Add(FixedPoint{t.size.X / 2, t.size.Y / 2})
+	unit.prevPos = unit.pos
+	unit.team = team
+	// Assign the parameters of the requested type
+	if err := us.setUnitParameters(unit, t); err != nil {
+		return nil, err
+	}
+	return unit, nil
 }
 
-// NewUnit create a new unit entity
-func (us *UnitSpawner) newUnit(posx float32, posy float32, unitID int) Unit {
-	// Create empty unit entity
-	unit := BasicUnit{BasicEntity: ecs.NewBasic()}
-	unit.position = engo.Point{X: posx, Y: posy}
-	// Assign the correct unit parameters according to requested ID
-	u := us.giveUnitParameters(&unit, unitID)
-	return u
-}
-
-// stepUnit move the unit a single step in the direction given by transx and transy
-func (unit *BasicUnit) step(transx float32, transy float32) {
-	if transx > 0 {
-		unit.SpaceComponent.Position.X += unit.speed
-		unit.shadow.SpaceComponent.Position.X += unit.speed
-	} else if transx < 0 {
-		unit.SpaceComponent.Position.X -= unit.speed
-		unit.shadow.SpaceComponent.Position.X -= unit.speed
-	} else if transy > 0 {
-		unit.SpaceComponent.Position.Y += unit.speed
-		unit.shadow.SpaceComponent.Position.Y += unit.speed
-	} else if transy < 0 {
-		unit.SpaceComponent.Position.Y -= unit.speed
-		unit.shadow.SpaceComponent.Position.Y -= unit.speed
-	}
-	// Else, both translations are 0 and do a noop
-}
-
-// Select select a unit and color shadow
+// Type the type of the unit
+func (unit *BasicUnit) Type() *UnitType {
+	return unit.kind
+}
+
+// step move the unit a single step along trans, at most its stride
+func (unit *BasicUnit) step(trans FixedPoint) {
+	dist := trans.Len()
+	if dist == 0 {
+		return
+	}
+	speed := unit.stride()
+	unit.heading = trans.Normalize()
+	if dist > speed {
+		trans = trans.Scale(speed.Div(dist))
+	}
+	unit.pos = unit.pos.Add(trans)
+}
+
+// stride the distance the unit moves in a tick: its speed, or the pace of its
+// group when that is slower
+func (unit *BasicUnit) stride() Fixed {
+	if unit.pace > 0 && unit.pace < unit.speed {
+		return unit.pace
+	}
+	return unit.speed
+}
+
+// Select select a unit and light up its shadow
 func (unit *BasicUnit) Select() {
 	unit.selected = true
-	unit.shadow.RenderComponent.Color = color.RGBA{0, 255, 0, 255}
+	c := unit.team.Color()
+	unit.shadow.RenderComponent.Color = color.RGBA{c.R/2 + 128, c.G/2 + 128, c.B/2 + 128, 255}
 }
 
-// Deselect deselect a unit and make shadow black again
+// Deselect deselect a unit and give its shadow the team colour again
 func (unit *BasicUnit) Deselect() {
 	unit.selected = false
-	unit.shadow.RenderComponent.Color = color.RGBA{0, 0, 0, 255}
+	unit.shadow.RenderComponent.Color = unit.team.Color()
+}
+
+// Team the team that owns the unit
+func (unit *BasicUnit) Team() Team {
+	return unit.team
+}
+
+// Hostile check if two units fight each other
+func (us *UnitSpawner) Hostile(a, b *BasicUnit) bool {
+	return a != b && us.Diplomacy.Hostile(a.team, b.team)
 }
 
 // Move move unit to target location
 func (unit *BasicUnit) Move(ast AStar, cfg AStarConfig, target engo.Point) {
-	source := []Point{EngoToPathing(unit.SpaceComponent.Center())}
+	source := []Point{FixedToPathing(unit.pos)}
 	ttarget := []Point{EngoToPathing(target)}
 	end := ast.FindPath(cfg, source, ttarget)
 	unit.path = end
 }
 
+// RequestMove order a unit to move to target without blocking, the path is
+// searched in the background and picked up by the unit in a later tick
+func (us *UnitSpawner) RequestMove(unit *BasicUnit, target engo.Point) {
+	us.Issue(Command{Units: []UnitID{unit.id}, Kind: OrderMove, Target: ToFixedPoint(target)})
+}
+
+// MoveGroup order several units to the same target. The group takes the
+// formation of the spawner around the target, every unit walking to its own
+// slot at the speed of the slowest unit. Loose groups share a single flow
+// field when the grid supports it. Flow fields are cached per target on the
+// grid, so later groups ordered to the same tile reuse it too.
+func (us *UnitSpawner) MoveGroup(units []*BasicUnit, target engo.Point) {
+	us.Issue(Command{Units: unitIDs(units), Kind: OrderMove, Target: ToFixedPoint(target), Formation: us.Formation})
+}
+
+// moveGroup search the paths of a group move, without touching their orders.
+// Returns the target of every unit.
+func (us *UnitSpawner) moveGroup(units []*BasicUnit, target FixedPoint, formation Formation) []FixedPoint {
+	targets := make([]FixedPoint, len(units))
+	for i := range targets {
+		targets[i] = target
+	}
+	if len(units) < 2 {
+		for _, unit := range units {
+			us.paths.Request(unit, target)
+		}
+		return targets
+	}
+
+	if formation == FormationLoose {
+		if _, ok := us.ast.(FlowFielder); ok {
+			us.paths.RequestFlow(units, FixedToPathing(target))
+			return targets
+		}
+		for _, unit := range units {
+			us.paths.Request(unit, target)
+		}
+		return targets
+	}
+
+	positions := make([]FixedPoint, len(units))
+	for i, unit := range units {
+		positions[i] = unit.pos
+	}
+	targets = us.formationTargets(units, positions, target, formation)
+	pace := slowestSpeed(units)
+	for i, unit := range units {
+		unit.pace = pace
+		us.paths.Request(unit, targets[i])
+	}
+	return targets
+}
+
 // Register the unit to the spawner
 func (unit *BasicUnit) Register(us *UnitSpawner) {
 	for _, system := range us.world.Systems() {
@@ -191,6 +458,7 @@ func (unit *BasicUnit) Register(us *UnitSpawner) {
 		case *common.RenderSystem:
 			sys.Add(&unit.BasicEntity, &unit.RenderComponent, &unit.SpaceComponent)
 			sys.Add(&unit.shadow.BasicEntity, &unit.shadow.RenderComponent, &unit.shadow.SpaceComponent)
+			sys.Add(&unit.healthBar.BasicEntity, &unit.healthBar.RenderComponent, &unit.healthBar.SpaceComponent)
 		case *common.MouseSystem:
 			sys.Add(&unit.BasicEntity, &unit.MouseComponent, &unit.SpaceComponent, &unit.RenderComponent)
 		case *common.CollisionSystem:
@@ -203,26 +471,148 @@ func (unit *BasicUnit) Register(us *UnitSpawner) {
 	}
 }
 
-// SpawnUnitAtLocation spawn new unit at the given location
-func (us *UnitSpawner) SpawnUnitAtLocation(x float32, y float32, unitID int) {
-	unit := us.newUnit(x, y, unitID)
-	unit.Register(us)
+// SpawnUnitAtLocation spawn new unit of the named type owned by team at the given location
+func (us *UnitSpawner) SpawnUnitAtLocation(x float32, y float32, unitType string, team Team) (*BasicUnit, error) {
+	unit, err := us.newUnit(x, y, unitType, team)
+	if err != nil {
+		return nil, err
+	}
+	us.addUnit(unit)
+	if us.Record != nil && us.tick == 0 && !us.ticking {
+		// Units placed before the first tick are the setup of the game, later
+		// ones are spawned by the simulation itself
+		us.Record.Units = append(us.Record.Units, ScenarioUnit{Type: unitType, Team: team, X: x, Y: y})
+	}
+	return unit, nil
+}
+
+// addUnit register a new unit with the world, or only with the spawner when
+// headless
+func (us *UnitSpawner) addUnit(unit *BasicUnit) {
+	if us.Headless {
+		us.Add(unit)
+	} else {
+		unit.Register(us)
+	}
 }
 
 // Update is ran every frame, with `dt` being the time
-// in seconds since the last frame
+// in seconds since the last frame. It runs the ticks that are due and draws the
+// units in between their last two tick positions.
 func (us *UnitSpawner) Update(dt float32) {
+	speed := us.Speed
+	if speed == 0 {
+		speed = 1
+	}
+	if !us.Paused {
+		us.lag += dt * speed
+	}
+	for ticks := 0; us.lag >= TickDuration; ticks++ {
+		if ticks == int(maxTicksPerUpdate*speed) {
+			us.lag = 0
+			break
+		}
+		if !us.paths.Ready() {
+			// Hold the clock until the paths the tick hands out are found,
+			// rather than stall the frame on them
+			us.lag = TickDuration
+			break
+		}
+		if !us.Tick() {
+			// Wait for the commands without running ahead once they are there
+			us.lag = TickDuration
+			break
+		}
+		us.lag -= TickDuration
+	}
+	us.interpolate(us.lag / TickDuration)
+	us.applyFog()
+	us.drawWaypoints()
+}
+
+// move step every unit along its path or flow field
+func (us *UnitSpawner) move() {
 	for _, unit := range us.AliveUnits {
-		fmt.Println(unit.CollisionComponent)
-		if unit.path != nil && unit.path.Parent != nil {
-
-			nextTarget := PathingToEngo(unit.path.Point)
-			transx := float32(nextTarget.X) - unit.SpaceComponent.Center().X
-			transy := float32(nextTarget.Y) - unit.SpaceComponent.Center().Y
-			unit.step(transx, transy)
-			if math.Abs(float64(transx))+math.Abs(float64(transy)) < 4 {
+		if unit.path != nil {
+			// Waypoints can be several tiles apart on a smoothed path, step
+			// straight towards the next one until it is reached
+			from := unit.pos
+			reached := unit.walkTowards(PathingToFixed(unit.path.Point))
+			if us.steppedOnBlocked(from, unit.pos) {
+				unit.pos = from
+				us.blocked(unit)
+				continue
+			}
+			unit.blockedFor = 0
+			if reached {
 				unit.path = unit.path.Parent
+				unit.repaths = 0
+			}
+			if unit.path == nil {
+				unit.pace = 0
 			}
+		} else if unit.flow != nil {
+			us.followFlow(unit)
 		}
 	}
 }
+
+// refreshFlows request a rebuild, once per field, of the flow fields that went
+// stale because the grid changed. Units keep following the old field until
+// the new one is delivered.
+func (us *UnitSpawner) refreshFlows() {
+	var fields []*FlowField
+	stale := make(map[*FlowField][]*BasicUnit)
+	for _, unit := range us.AliveUnits {
+		if unit.flow == nil || us.paths.Pending(unit) || !unit.flow.Stale() {
+			continue
+		}
+		if _, ok := stale[unit.flow]; !ok {
+			fields = append(fields, unit.flow)
+		}
+		stale[unit.flow] = append(stale[unit.flow], unit)
+	}
+	for _, field := range fields {
+		us.paths.RequestFlow(stale[field], field.Target)
+	}
+}
+
+// walkTowards step the unit towards a point, returns true once it is reached
+func (unit *BasicUnit) walkTowards(target FixedPoint) bool {
+	trans := target.Sub(unit.pos)
+	unit.step(trans)
+	return trans.Len() <= unit.stride()
+}
+
+// followFlow step the unit along its flow field
+func (us *UnitSpawner) followFlow(unit *BasicUnit) {
+	next, ok := unit.flow.Next(FixedToPathing(unit.pos))
+	if !ok {
+		// Target can not be reached (anymore)
+		unit.flow = nil
+		return
+	}
+	from := unit.pos
+	reached := unit.walkTowards(PathingToFixed(next))
+	if us.steppedOnBlocked(from, unit.pos) {
+		// The field is out of date, a new one is on its way
+		unit.pos = from
+		us.blocked(unit)
+		return
+	}
+	unit.blockedFor = 0
+	if reached && next == unit.flow.Target {
+		unit.flow = nil
+	}
+}
+
+// steppedOnBlocked check if a step from one point to the other entered an
+// impassable tile, which was filled after the unit planned its way
+func (us *UnitSpawner) steppedOnBlocked(from, to FixedPoint) bool {
+	grid, ok := us.ast.(Grid)
+	if !ok {
+		return false
+	}
+	tile := FixedToPathing(to)
+	return tile != FixedToPathing(from) && grid.Weight(tile) == -1
+}
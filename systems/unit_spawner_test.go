@@ -0,0 +1,19 @@
+package systems
+
+import "testing"
+
+func TestWalkTowardsAtPace(t *testing.T) {
+	unit := &BasicUnit{speed: FixedFromInt(4), pace: FixedFromInt(1)}
+	target := FixedPoint{X: FixedFromInt(3)}
+	if unit.walkTowards(target) {
+		t.Fatal("a unit at pace 1 reached a point 3 away in one step")
+	}
+	for steps := 1; !unit.walkTowards(target); steps++ {
+		if steps == 5 {
+			t.Fatalf("a unit at pace 1 did not reach a point 3 away in %d steps", steps)
+		}
+	}
+	if dist := unit.pos.Dist(target); dist > FixedOne/100 {
+		t.Errorf("unit stopped %v short of its target", dist.Float())
+	}
+}
@@ -0,0 +1,254 @@
+package systems
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/EngoEngine/engo/common"
+)
+
+// UnitTypesURL definitions file of the unit types, relative to AssetRoot
+const UnitTypesURL = "units/units.json"
+
+// Defaults for unit type settings that are left out of the definitions
+const (
+	defaultUnitScale     = 1
+	defaultAnimationRate = 0.5
+)
+
+// UnitType archetype of a unit, everything units of the same type share
+type UnitType struct {
+	Name string `json:"name"`
+
+	// Visuals
+	Texture       string          `json:"texture"` // spritesheet asset url
+	CellWidth     int             `json:"cellWidth"`
+	CellHeight    int             `json:"cellHeight"`
+	Cell          int             `json:"cell"`  // spritesheet cell of the unit
+	Scale         float32         `json:"scale"` // pixels per texture pixel
+	AnimationRate float32         `json:"animationRate"`
+	Animations    []UnitAnimation `json:"animations"` // the first one is the default
+
+	// Movement, in pixels per second
+	Speed float32 `json:"speed"`
+
+	// Combat
+	HP       int     `json:"hp"`
+	Damage   int     `json:"damage"`
+	Range    float32 `json:"range"`    // pixels between the unit bodies
+	Cooldown float32 `json:"cooldown"` // seconds between attacks
+
+	// Sight pixels around the unit it sees, defaultSight when left out
+	Sight float32 `json:"sight"`
+
+	// Gathering, only workers have a capacity
+	Capacity   int     `json:"capacity"`   // resources carried per trip
+	GatherTime float32 `json:"gatherTime"` // seconds to harvest a load
+
+	// Production in buildings
+	Cost      int     `json:"cost"`      // resources a unit costs to train
+	BuildTime float32 `json:"buildTime"` // seconds a unit takes to train
+
+	sheet *common.Spritesheet
+
+	// Settings in simulation units, worked out once when the type is read
+	size          FixedPoint // pixels the sprite covers
+	radius        Fixed
+	speed         Fixed // pixels per tick
+	reach         Fixed
+	cooldownTicks int
+	sight         int // pathing tiles
+	gatherTicks   int
+	buildTicks    int
+}
+
+// UnitAnimation animation of a unit type, frames are spritesheet cells
+type UnitAnimation struct {
+	Name   string `json:"name"`
+	Frames []int  `json:"frames"`
+	Loop   bool   `json:"loop"`
+}
+
+// UnitTypes registry of the unit types, by name
+type UnitTypes struct {
+	URL   string
+	types map[string]*UnitType
+}
+
+// unitTypesFile layout of the definitions file
+type unitTypesFile struct {
+	Units []*UnitType `json:"units"`
+}
+
+// LoadUnitTypes read the unit type definitions from a JSON file
+func LoadUnitTypes(url string) (*UnitTypes, error) {
+	data, err := os.ReadFile(filepath.Join(AssetRoot, filepath.FromSlash(url)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read unit types %s: %v", url, err)
+	}
+	types, err := ParseUnitTypes(data)
+	if err != nil {
+		return nil, fmt.Errorf("unit types %s: %v", url, err)
+	}
+	types.URL = url
+	return types, nil
+}
+
+// ParseUnitTypes read unit type definitions and check that they are complete
+func ParseUnitTypes(data []byte) (*UnitTypes, error) {
+	var file unitTypesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	types := &UnitTypes{types: make(map[string]*UnitType)}
+	for _, t := range file.Units {
+		if t.Scale == 0 {
+			t.Scale = defaultUnitScale
+		}
+		if t.AnimationRate == 0 {
+			t.AnimationRate = defaultAnimationRate
+		}
+		if t.Sight == 0 {
+			t.Sight = defaultSight
+		}
+		if err := t.validate(); err != nil {
+			return nil, err
+		}
+		t.prepare()
+		if _, ok := types.types[t.Name]; ok {
+			return nil, fmt.Errorf("unit type %q is defined twice", t.Name)
+		}
+		types.types[t.Name] = t
+	}
+	return types, nil
+}
+
+// validate check the settings of a unit type
+func (t *UnitType) validate() error {
+	switch {
+	case t.Name == "":
+		return fmt.Errorf("unit type without a name")
+	case t.Texture == "":
+		return fmt.Errorf("unit type %q: no texture", t.Name)
+	case t.CellWidth <= 0 || t.CellHeight <= 0:
+		return fmt.Errorf("unit type %q: cell size must be positive", t.Name)
+	case t.Cell < 0:
+		return fmt.Errorf("unit type %q: negative cell", t.Name)
+	case t.Scale < 0 || t.AnimationRate < 0:
+		return fmt.Errorf("unit type %q: negative scale or animation rate", t.Name)
+	case t.Speed <= 0:
+		return fmt.Errorf("unit type %q: speed must be positive", t.Name)
+	case t.HP <= 0:
+		return fmt.Errorf("unit type %q: hp must be positive", t.Name)
+	case t.Damage < 0 || t.Range < 0 || t.Cooldown < 0:
+		return fmt.Errorf("unit type %q: negative combat stats", t.Name)
+	case t.Sight < 0:
+		return fmt.Errorf("unit type %q: negative sight", t.Name)
+	case t.Capacity < 0 || t.GatherTime < 0:
+		return fmt.Errorf("unit type %q: negative gathering stats", t.Name)
+	case t.Cost < 0 || t.BuildTime < 0:
+		return fmt.Errorf("unit type %q: negative production stats", t.Name)
+	}
+	for _, anim := range t.Animations {
+		if anim.Name == "" || len(anim.Frames) == 0 {
+			return fmt.Errorf("unit type %q: animations need a name and frames", t.Name)
+		}
+		for _, frame := range anim.Frames {
+			if frame < 0 {
+				return fmt.Errorf("unit type %q: negative frame in animation %q", t.Name, anim.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// prepare convert the settings the simulation uses to fixed-point and ticks
+func (t *UnitType) prepare() {
+	t.size = FixedPoint{FixedFromFloat(float32(t.CellWidth) * t.Scale), FixedFromFloat(float32(t.CellHeight) * t.Scale)}
+	// The sprite is drawn a lot larger than the body itself
+	t.radius = t.size.X / 4
+	t.speed = FixedFromFloat(t.Speed / TickRate)
+	t.reach = FixedFromFloat(t.Range)
+	t.cooldownTicks = int(math.Round(float64(t.Cooldown) * TickRate))
+	t.sight = int(t.Sight / discreteStep)
+	t.gatherTicks = int(math.Round(float64(t.GatherTime) * TickRate))
+	if t.gatherTicks < 1 {
+		t.gatherTicks = 1
+	}
+	t.buildTicks = int(math.Round(float64(t.BuildTime) * TickRate))
+	if t.buildTicks < 1 {
+		t.buildTicks = 1
+	}
+}
+
+// Lookup the unit type with the given name
+func (u *UnitTypes) Lookup(name string) (*UnitType, error) {
+	t, ok := u.types[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown unit type %q", name)
+	}
+	return t, nil
+}
+
+// Names of all unit types, sorted
+func (u *UnitTypes) Names() []string {
+	names := make([]string, 0, len(u.types))
+	for name := range u.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Images the asset urls of the spritesheets of the unit types, to be loaded
+// by engo before units are spawned
+func (u *UnitTypes) Images() []string {
+	seen := make(map[string]bool)
+	var urls []string
+	for _, name := range u.Names() {
+		t := u.types[name]
+		if !seen[t.Texture] {
+			seen[t.Texture] = true
+			urls = append(urls, t.Texture)
+		}
+	}
+	return urls
+}
+
+// spritesheet of the unit type, made on first use once the texture is loaded
+func (t *UnitType) spritesheet() (*common.Spritesheet, error) {
+	if t.sheet != nil {
+		return t.sheet, nil
+	}
+	sheet := common.NewSpritesheetFromFile(t.Texture, t.CellWidth, t.CellHeight)
+	if sheet == nil {
+		return nil, fmt.Errorf("unit type %q: texture %s is not loaded", t.Name, t.Texture)
+	}
+	cells := sheet.CellCount()
+	if t.Cell >= cells {
+		return nil, fmt.Errorf("unit type %q: cell %d is not in %s", t.Name, t.Cell, t.Texture)
+	}
+	for _, anim := range t.Animations {
+		for _, frame := range anim.Frames {
+			if frame >= cells {
+				return nil, fmt.Errorf("unit type %q: frame %d of animation %q is not in %s", t.Name, frame, anim.Name, t.Texture)
+			}
+		}
+	}
+	t.sheet = sheet
+	return sheet, nil
+}
+
+// animations the engo animations of the unit type, the default one first
+func (t *UnitType) animations() []*common.Animation {
+	anims := make([]*common.Animation, len(t.Animations))
+	for i, anim := range t.Animations {
+		anims[i] = &common.Animation{Name: anim.Name, Frames: anim.Frames, Loop: anim.Loop}
+	}
+	return anims
+}
@@ -0,0 +1,317 @@
+package systems
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/EngoEngine/ecs"
+	"github.com/EngoEngine/engo"
+	"github.com/EngoEngine/engo/common"
+)
+
+// Visibility how much a team knows of a pathing tile
+type Visibility uint8
+
+// Visibilities of a tile
+const (
+	Unexplored Visibility = iota // never seen
+	Explored                     // seen before, but not right now
+	Visible                      // seen by a unit of the team
+)
+
+// Fog settings
+const (
+	// Sight of unit types that do not set one, in pixels
+	defaultSight = 256
+)
+
+// Colours of the fog over tiles the player does not see
+var (
+	unexploredFog = color.NRGBA{0, 0, 0, 255}
+	exploredFog   = color.NRGBA{0, 0, 0, 140}
+)
+
+// VisionGrid what a team sees, on the tiles of the pathing grid
+type VisionGrid struct {
+	rows, cols int
+	tiles      []Visibility
+	last       []Visibility // tiles before the units last looked
+	changed    bool         // tiles changed since the fog was last drawn
+}
+
+// newVisionGrid an unexplored grid
+func newVisionGrid(rows, cols int) *VisionGrid {
+	return &VisionGrid{rows: rows, cols: cols, tiles: make([]Visibility, rows*cols), changed: true}
+}
+
+// At the visibility of a tile, tiles outside the grid are never seen
+func (v *VisionGrid) At(p Point) Visibility {
+	if p.X < 0 || p.Y < 0 || p.X >= v.rows || p.Y >= v.cols {
+		return Unexplored
+	}
+	return v.tiles[p.X*v.cols+p.Y]
+}
+
+// set the visibility of a tile inside the grid
+func (v *VisionGrid) set(p Point, vis Visibility) {
+	v.tiles[p.X*v.cols+p.Y] = vis
+}
+
+// fade turn what was visible into explored, before the units look again
+func (v *VisionGrid) fade() {
+	v.last = append(v.last[:0], v.tiles...)
+	for i, vis := range v.tiles {
+		if vis == Visible {
+			v.tiles[i] = Explored
+		}
+	}
+}
+
+// settle note whether the units saw anything different from last time
+func (v *VisionGrid) settle() {
+	if v.changed {
+		return
+	}
+	for i, vis := range v.tiles {
+		if vis != v.last[i] {
+			v.changed = true
+			return
+		}
+	}
+}
+
+// sightBlockers the impassable tiles of a grid, which block sight, by tile
+type sightBlockers struct {
+	version uint64
+	blocked []bool
+}
+
+// update read the impassable tiles again if the grid changed since
+func (b *sightBlockers) update(grid Grid) {
+	rows, cols := grid.Size()
+	version := grid.Version()
+	if len(b.blocked) == rows*cols && b.version == version {
+		return
+	}
+	b.version = version
+	b.blocked = make([]bool, rows*cols)
+	for x := 0; x < rows; x++ {
+		for y := 0; y < cols; y++ {
+			b.blocked[x*cols+y] = grid.Weight(Point{X: x, Y: y}) == -1
+		}
+	}
+}
+
+// look mark what a unit at origin sees within radius tiles. Rays go out to
+// every tile on the edge of the square around the unit and stop at the first
+// impassable tile, which is itself seen.
+func (v *VisionGrid) look(blockers *sightBlockers, origin Point, radius int) {
+	if origin.X < 0 || origin.Y < 0 || origin.X >= v.rows || origin.Y >= v.cols {
+		return
+	}
+	v.set(origin, Visible)
+	for i := -radius; i <= radius; i++ {
+		v.ray(blockers, origin, Point{X: origin.X + i, Y: origin.Y - radius}, radius)
+		v.ray(blockers, origin, Point{X: origin.X + i, Y: origin.Y + radius}, radius)
+		v.ray(blockers, origin, Point{X: origin.X - radius, Y: origin.Y + i}, radius)
+		v.ray(blockers, origin, Point{X: origin.X + radius, Y: origin.Y + i}, radius)
+	}
+}
+
+// ray mark the tiles on the line from origin towards end as visible, up to
+// radius tiles away or the first impassable tile
+func (v *VisionGrid) ray(blockers *sightBlockers, origin, end Point, radius int) {
+	dx, dy := abs(end.X-origin.X), -abs(end.Y-origin.Y)
+	sx, sy := 1, 1
+	if end.X < origin.X {
+		sx = -1
+	}
+	if end.Y < origin.Y {
+		sy = -1
+	}
+	p, e := origin, dx+dy
+	for p != end {
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			p.X += sx
+		}
+		if e2 <= dx {
+			e += dx
+			p.Y += sy
+		}
+		x, y := p.X-origin.X, p.Y-origin.Y
+		if x*x+y*y > radius*radius || p.X < 0 || p.Y < 0 || p.X >= v.rows || p.Y >= v.cols {
+			return
+		}
+		i := p.X*v.cols + p.Y
+		v.tiles[i] = Visible
+		if blockers.blocked[i] {
+			return
+		}
+	}
+}
+
+// abs the absolute value of an int
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// Vision what a team sees, nil for a team without units so far
+func (us *UnitSpawner) Vision(team Team) *VisionGrid {
+	return us.vision[team]
+}
+
+// see work out what every team sees after the units moved
+func (us *UnitSpawner) see() {
+	grid, ok := us.ast.(Grid)
+	if !ok {
+		return
+	}
+	us.blockers.update(grid)
+	for _, vision := range us.vision {
+		vision.fade()
+	}
+	for _, unit := range us.AliveUnits {
+		vision := us.vision[unit.team]
+		if vision == nil {
+			rows, cols := grid.Size()
+			vision = newVisionGrid(rows, cols)
+			us.vision[unit.team] = vision
+		}
+		vision.look(&us.blockers, FixedToPathing(unit.pos), unit.kind.sight)
+	}
+	for _, vision := range us.vision {
+		vision.settle()
+	}
+}
+
+// Seen check if a team or its allies see a tile
+func (us *UnitSpawner) Seen(team Team, p Point) bool {
+	for other, vision := range us.vision {
+		if us.Diplomacy.Allied(team, other) && vision.At(p) == Visible {
+			return true
+		}
+	}
+	return false
+}
+
+// VisibleTo check if a team sees a unit, it always sees its own and allied
+// units
+func (us *UnitSpawner) VisibleTo(team Team, unit *BasicUnit) bool {
+	return us.Diplomacy.Allied(team, unit.team) || us.Seen(team, FixedToPathing(unit.pos))
+}
+
+// playerVisions the vision grids of the player and its allies
+func (us *UnitSpawner) playerVisions() []*VisionGrid {
+	var visions []*VisionGrid
+	for team, vision := range us.vision {
+		if us.Diplomacy.Allied(us.Player, team) {
+			visions = append(visions, vision)
+		}
+	}
+	return visions
+}
+
+// sees the best visibility any of the grids has of a tile
+func sees(visions []*VisionGrid, p Point) Visibility {
+	best := Unexplored
+	for _, vision := range visions {
+		if vis := vision.At(p); vis > best {
+			best = vis
+		}
+	}
+	return best
+}
+
+// fogOverlay entity that darkens what the player does not see, a pixel per
+// pathing tile stretched over the level
+type fogOverlay struct {
+	ecs.BasicEntity
+	common.RenderComponent
+	common.SpaceComponent
+	image *image.NRGBA
+}
+
+// newFog add the fog overlay to the world
+func (us *UnitSpawner) newFog() {
+	rows, cols := us.ast.(Grid).Size()
+	fog := &fogOverlay{BasicEntity: ecs.NewBasic(), image: image.NewNRGBA(image.Rect(0, 0, rows, cols))}
+	fog.SpaceComponent = common.SpaceComponent{Width: float32(rows * discreteStep), Height: float32(cols * discreteStep)}
+	fog.RenderComponent = common.RenderComponent{Scale: engo.Point{X: discreteStep, Y: discreteStep}}
+	// Above the units and their health bars
+	fog.RenderComponent.SetZIndex(2)
+	for _, system := range us.world.Systems() {
+		switch sys := system.(type) {
+		case *common.RenderSystem:
+			sys.Add(&fog.BasicEntity, &fog.RenderComponent, &fog.SpaceComponent)
+		}
+	}
+	us.fog = fog
+	us.drawFog(true)
+}
+
+// drawFog redraw the fog if the vision of the player and its allies changed,
+// or when forced
+func (us *UnitSpawner) drawFog(force bool) {
+	changed := force
+	for team, vision := range us.vision {
+		changed = changed || vision.changed && us.Diplomacy.Allied(us.Player, team)
+		vision.changed = false
+	}
+	if !changed {
+		return
+	}
+	visions := us.playerVisions()
+	bounds := us.fog.image.Bounds()
+	for x := 0; x < bounds.Dx(); x++ {
+		for y := 0; y < bounds.Dy(); y++ {
+			switch sees(visions, Point{X: x, Y: y}) {
+			case Unexplored:
+				us.fog.image.SetNRGBA(x, y, unexploredFog)
+			case Explored:
+				us.fog.image.SetNRGBA(x, y, exploredFog)
+			default:
+				us.fog.image.SetNRGBA(x, y, color.NRGBA{})
+			}
+		}
+	}
+	if us.fog.Drawable != nil {
+		us.fog.Drawable.Close()
+	}
+	us.fog.Drawable = common.NewTextureSingle(common.NewImageObject(us.fog.image))
+}
+
+// applyFog hide the enemies the player does not see and the nodes and enemy
+// buildings it has not explored yet, and redraw the fog
+func (us *UnitSpawner) applyFog() {
+	if us.fog == nil {
+		return
+	}
+	for _, unit := range us.AliveUnits {
+		unit.hide(!us.VisibleTo(us.Player, unit))
+	}
+	visions := us.playerVisions()
+	for _, node := range us.nodes {
+		node.RenderComponent.Hidden = sees(visions, FixedToPathing(node.rect.center())) == Unexplored
+	}
+	for _, b := range us.buildings {
+		b.hide(!us.Diplomacy.Allied(us.Player, b.team) &&
+			sees(visions, FixedToPathing(b.rect.center())) == Unexplored)
+	}
+	us.drawFog(false)
+}
+
+// hide a unit and everything drawn with it, or show it again
+func (unit *BasicUnit) hide(hidden bool) {
+	if unit.hidden == hidden {
+		return
+	}
+	unit.hidden = hidden
+	unit.RenderComponent.Hidden = hidden
+	unit.shadow.RenderComponent.Hidden = hidden
+	unit.showHealth()
+}